@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hrusfandi/sb-task-management/database"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage:
+  main                         start the API server
+  main unlock <email|ip>...    clear failed login attempts and lift lockouts
+  main set-role <email> <role> change the role of a user (user or admin)
+  main verify-audit-log        check that the audit log has not been altered
+  main normalize-emails        rewrite stored emails in their canonical form`
+
+// runCommand executes a maintenance command instead of starting the server
+func runCommand(args []string) error {
+	switch args[0] {
+	case "unlock":
+		return unlockLogins(args[1:])
+	case "set-role":
+		return setRole(args[1:])
+	case "verify-audit-log":
+		return verifyAuditLog()
+	case "normalize-emails":
+		return normalizeEmails()
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+func unlockLogins(targets []string) error {
+	if len(targets) == 0 {
+		return errors.New("unlock needs at least one email or IP address")
+	}
+
+	keys := make([]string, 0, len(targets))
+	for _, target := range targets {
+		switch {
+		case net.ParseIP(target) != nil:
+			keys = append(keys, models.LoginAttemptKeyIP+target)
+		case strings.Contains(target, "@"):
+			keys = append(keys, models.LoginAttemptKeyEmail+utils.NormalizeEmail(target))
+		default:
+			return fmt.Errorf("%q is neither an email nor an IP address", target)
+		}
+	}
+
+	database.InitDB()
+	cleared, err := models.NewLoginAttemptRepository(database.GetDB()).ClearLoginAttempts(keys...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cleared %d tracked login failure record(s)\n", cleared)
+	return nil
+}
+
+func setRole(args []string) error {
+	if len(args) != 2 {
+		return errors.New("set-role needs an email and a role")
+	}
+	email, role := utils.NormalizeEmail(args[0]), args[1]
+	if !models.ValidRoles[role] {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	database.InitDB()
+	db := database.GetDB()
+	userRepo := models.NewUserRepository(db)
+	user, err := userRepo.GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("find user %s: %w", email, err)
+	}
+	details, err := json.Marshal(map[string]string{"role": role, "previous_role": user.Role, "source": "cli"})
+	if err != nil {
+		return err
+	}
+	// The role only changes together with its audit event
+	err = models.NewAuditEventRepository(db).AppendAuditEventWith(&models.AuditEvent{
+		Action:     models.AuditActionRoleChange,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &user.ID,
+		Details:    string(details),
+	}, func(tx *gorm.DB) error {
+		return userRepo.WithTx(tx).SetRole(user.ID, role)
+	})
+	if err != nil {
+		return fmt.Errorf("change role of %s: %w", email, err)
+	}
+
+	fmt.Printf("%s is now %s; existing sessions were signed out\n", email, role)
+	return nil
+}
+
+func verifyAuditLog() error {
+	database.InitDB()
+	checked, broken, err := models.NewAuditEventRepository(database.GetDB()).VerifyAuditChain()
+	if err != nil {
+		return err
+	}
+	if broken != nil {
+		return fmt.Errorf("audit log chain is broken at event %d (%d event(s) before it are intact)", broken.ID, checked)
+	}
+
+	fmt.Printf("Audit log intact: %d event(s) checked\n", checked)
+	return nil
+}
+
+// normalizeEmails brings emails stored before normalization was introduced,
+// e.g. with an internationalized domain, into the form lookups now use.
+// Addresses that would collide with another account are reported and left
+// for an admin to resolve.
+func normalizeEmails() error {
+	database.InitDB()
+	db := database.GetDB()
+
+	var users []models.User
+	if err := db.Unscoped().Select("id", "email").Order("id").Find(&users).Error; err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, user := range users {
+		email := utils.NormalizeEmail(user.Email)
+		if email == user.Email {
+			continue
+		}
+		err := db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Update("email", email).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			fmt.Printf("Skipped user %d: %s normalizes to %s, which another account uses\n", user.ID, user.Email, email)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		updated++
+	}
+
+	fmt.Printf("Normalized %d email address(es)\n", updated)
+	return nil
+}
@@ -3,18 +3,119 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	EmailVerificationOff   = "off"
+	EmailVerificationLogin = "login"
+	EmailVerificationTasks = "tasks"
+)
+
+// OIDCStateTTL is how long a signed OIDC login state stays valid, i.e. how
+// long users have to finish signing in at the identity provider
+const OIDCStateTTL = 10 * time.Minute
+
 type Config struct {
-	DBHost     string
-	DBPort     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	JWTSecret  string
-	Port       string
+	DBHost          string
+	DBPort          string
+	DBUser          string
+	DBPassword      string
+	DBName          string
+	JWTSecret       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Port            string
+
+	// ImpersonationTTL is the lifetime of the tokens admins get to act as
+	// another user
+	ImpersonationTTL time.Duration
+
+	JWTSigningAlgorithm    string
+	JWTKeysDir             string
+	JWTKeyRotationInterval time.Duration
+
+	AppBaseURL                   string
+	PasswordResetTTL             time.Duration
+	PasswordResetRequestInterval time.Duration
+
+	// EmailVerificationMode decides what unverified accounts are denied:
+	// nothing ("off"), logging in ("login") or the task endpoints ("tasks").
+	EmailVerificationMode           string
+	EmailVerificationTTL            time.Duration
+	EmailVerificationResendInterval time.Duration
+
+	MFAIssuer       string
+	MFAChallengeTTL time.Duration
+
+	// ReauthWindow is how recently accounts without a password or TOTP must
+	// have signed in to change their email or delete the account
+	ReauthWindow time.Duration
+
+	// MagicLinkEnabled allows passwordless logins through emailed links
+	MagicLinkEnabled         bool
+	MagicLinkTTL             time.Duration
+	MagicLinkRequestInterval time.Duration
+
+	MailDriver    string
+	MailFrom      string
+	MailOutboxDir string
+	SMTPHost      string
+	SMTPPort      string
+	SMTPUsername  string
+	SMTPPassword  string
+
+	PasswordMinLength          int
+	PasswordMaxLength          int
+	PasswordRequireUppercase   bool
+	PasswordRequireLowercase   bool
+	PasswordRequireDigit       bool
+	PasswordRequireSymbol      bool
+	PasswordMinStrength        int
+	PasswordRejectPersonalInfo bool
+	PasswordBreachedListDir    string
+
+	PasswordHashAlgorithm string
+	BcryptCost            int
+	Argon2Memory          int // KiB
+	Argon2Iterations      int
+	Argon2Parallelism     int
+
+	// Failed logins are tracked per email and per client IP. Each failure on
+	// an email delays the next attempt exponentially starting at
+	// LoginDelayBase; reaching the limit locks the email or IP out for
+	// LoginLockoutDuration.
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginDelayBase        time.Duration
+	LoginLockoutDuration  time.Duration
+
+	OIDCProviders     []OIDCProvider
+	OIDCAutoProvision bool
+
+	// Cookies set for browser logins that opt out of bearer tokens
+	SessionCookieDomain   string
+	SessionCookieSecure   bool
+	SessionCookieSameSite string // strict, lax or none
+
+	// EnforceTaskDependencies refuses to start or complete tasks that are
+	// blocked by unfinished tasks
+	EnforceTaskDependencies bool
+}
+
+// OIDCProvider is an external OpenID Connect identity provider users can sign
+// in with, configured through OIDC_<NAME>_* variables
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 var AppConfig *Config
@@ -26,17 +127,128 @@ func LoadConfig() {
 	}
 
 	AppConfig = &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", ""),
-		DBName:     getEnv("DB_NAME", "task_management"),
-		JWTSecret:  getEnv("JWT_SECRET", ""),
-		Port:       getEnv("PORT", "8080"),
+		DBHost:          getEnv("DB_HOST", "localhost"),
+		DBPort:          getEnv("DB_PORT", "5432"),
+		DBUser:          getEnv("DB_USER", "postgres"),
+		DBPassword:      getEnv("DB_PASSWORD", ""),
+		DBName:          getEnv("DB_NAME", "task_management"),
+		JWTSecret:       getEnv("JWT_SECRET", ""),
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		Port:            getEnv("PORT", "8080"),
+
+		ImpersonationTTL: getEnvDuration("IMPERSONATION_TTL", 15*time.Minute),
+
+		JWTSigningAlgorithm:    getEnv("JWT_SIGNING_ALG", "HS256"),
+		JWTKeysDir:             getEnv("JWT_KEYS_DIR", ""),
+		JWTKeyRotationInterval: getEnvDuration("JWT_KEY_ROTATION_INTERVAL", 0),
+
+		AppBaseURL:                   strings.TrimRight(getEnv("APP_BASE_URL", "http://localhost:8080"), "/"),
+		PasswordResetTTL:             getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetRequestInterval: getEnvDuration("PASSWORD_RESET_REQUEST_INTERVAL", time.Minute),
+
+		EmailVerificationMode:           getEnv("EMAIL_VERIFICATION_MODE", EmailVerificationOff),
+		EmailVerificationTTL:            getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		EmailVerificationResendInterval: getEnvDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute),
+
+		MFAIssuer:       getEnv("MFA_ISSUER", "Task Management"),
+		MFAChallengeTTL: getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+
+		ReauthWindow: getEnvDuration("REAUTH_WINDOW", 5*time.Minute),
+
+		MagicLinkEnabled:         getEnvBool("MAGIC_LINK_ENABLED", false),
+		MagicLinkTTL:             getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		MagicLinkRequestInterval: getEnvDuration("MAGIC_LINK_REQUEST_INTERVAL", time.Minute),
+
+		MailDriver:    getEnv("MAIL_DRIVER", "file"),
+		MailFrom:      getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailOutboxDir: getEnv("MAIL_OUTBOX_DIR", "outbox"),
+		SMTPHost:      getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:      getEnv("SMTP_PORT", "587"),
+		SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+
+		PasswordMinLength:          getEnvInt("PASSWORD_MIN_LENGTH", 6),
+		PasswordMaxLength:          getEnvInt("PASSWORD_MAX_LENGTH", 100),
+		PasswordRequireUppercase:   getEnvBool("PASSWORD_REQUIRE_UPPERCASE", false),
+		PasswordRequireLowercase:   getEnvBool("PASSWORD_REQUIRE_LOWERCASE", false),
+		PasswordRequireDigit:       getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol:      getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordMinStrength:        getEnvInt("PASSWORD_MIN_STRENGTH", 0),
+		PasswordRejectPersonalInfo: getEnvBool("PASSWORD_REJECT_PERSONAL_INFO", true),
+		PasswordBreachedListDir:    getEnv("PASSWORD_BREACHED_LIST_DIR", ""),
+
+		PasswordHashAlgorithm: getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		BcryptCost:            getEnvInt("BCRYPT_COST", 10),
+		Argon2Memory:          getEnvInt("ARGON2_MEMORY", 64*1024),
+		Argon2Iterations:      getEnvInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:     getEnvInt("ARGON2_PARALLELISM", 4),
+
+		LoginMaxFailures:      getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP: getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 50),
+		LoginDelayBase:        getEnvDuration("LOGIN_DELAY_BASE", time.Second),
+		LoginLockoutDuration:  getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+
+		OIDCAutoProvision: getEnvBool("OIDC_AUTO_PROVISION", true),
+
+		SessionCookieDomain:   getEnv("SESSION_COOKIE_DOMAIN", ""),
+		SessionCookieSecure:   getEnvBool("SESSION_COOKIE_SECURE", true),
+		SessionCookieSameSite: getEnv("SESSION_COOKIE_SAMESITE", "strict"),
+
+		EnforceTaskDependencies: getEnvBool("ENFORCE_TASK_DEPENDENCIES", false),
+	}
+	AppConfig.OIDCProviders = loadOIDCProviders(AppConfig.AppBaseURL)
+
+	switch AppConfig.EmailVerificationMode {
+	case EmailVerificationOff, EmailVerificationLogin, EmailVerificationTasks:
+	default:
+		log.Fatalf("EMAIL_VERIFICATION_MODE must be one of off, login or tasks, got %q", AppConfig.EmailVerificationMode)
 	}
 
-	if AppConfig.JWTSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+	switch AppConfig.SessionCookieSameSite {
+	case "strict", "lax":
+	case "none":
+		if !AppConfig.SessionCookieSecure {
+			log.Fatal("SESSION_COOKIE_SAMESITE=none requires SESSION_COOKIE_SECURE=true")
+		}
+	default:
+		log.Fatalf("SESSION_COOKIE_SAMESITE must be one of strict, lax or none, got %q", AppConfig.SessionCookieSameSite)
+	}
+
+	if AppConfig.PasswordMinStrength > 4 {
+		log.Fatalf("PASSWORD_MIN_STRENGTH must be between 0 and 4, got %d", AppConfig.PasswordMinStrength)
+	}
+	if AppConfig.PasswordMaxLength > 0 && AppConfig.PasswordMaxLength < AppConfig.PasswordMinLength {
+		log.Fatal("PASSWORD_MAX_LENGTH must not be lower than PASSWORD_MIN_LENGTH")
+	}
+
+	switch AppConfig.PasswordHashAlgorithm {
+	case "argon2id":
+		if AppConfig.Argon2Iterations < 1 {
+			log.Fatal("ARGON2_ITERATIONS must be at least 1")
+		}
+		if AppConfig.Argon2Parallelism < 1 || AppConfig.Argon2Parallelism > 255 {
+			log.Fatalf("ARGON2_PARALLELISM must be between 1 and 255, got %d", AppConfig.Argon2Parallelism)
+		}
+		if AppConfig.Argon2Memory < 8*AppConfig.Argon2Parallelism {
+			log.Fatal("ARGON2_MEMORY must be at least 8 KiB per degree of ARGON2_PARALLELISM")
+		}
+	case "bcrypt":
+		if AppConfig.BcryptCost < 4 || AppConfig.BcryptCost > 31 {
+			log.Fatalf("BCRYPT_COST must be between 4 and 31, got %d", AppConfig.BcryptCost)
+		}
+	default:
+		log.Fatalf("PASSWORD_HASH_ALGORITHM must be argon2id or bcrypt, got %q", AppConfig.PasswordHashAlgorithm)
+	}
+
+	switch AppConfig.JWTSigningAlgorithm {
+	case "HS256":
+		if AppConfig.JWTSecret == "" {
+			log.Fatal("JWT_SECRET environment variable is required")
+		}
+	case "RS256", "EdDSA":
+	default:
+		log.Fatalf("JWT_SIGNING_ALG must be one of HS256, RS256 or EdDSA, got %q", AppConfig.JWTSigningAlgorithm)
 	}
 }
 
@@ -47,6 +259,69 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a valid duration (e.g. 15m, 720h): %v", key, err)
+	}
+	return d
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative integer, got %q", key, value)
+	}
+	return n
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be true or false: %v", key, err)
+	}
+	return b
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS, e.g.
+// OIDC_PROVIDERS=google,corp with OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID...
+func loadOIDCProviders(baseURL string) []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", baseURL+"/api/oidc/"+name+"/callback"),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Fatalf("%sISSUER and %sCLIENT_ID are required for OIDC provider %q", prefix, prefix, name)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
 func GetDatabaseURL() string {
 	return "host=" + AppConfig.DBHost + " user=" + AppConfig.DBUser + " password=" + AppConfig.DBPassword + " dbname=" + AppConfig.DBName + " port=" + AppConfig.DBPort + " sslmode=disable"
-}
\ No newline at end of file
+}
@@ -24,6 +24,8 @@ func InitDB() {
 	var err error
 	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
+		// Report constraint violations as gorm errors, e.g. ErrDuplicatedKey
+		TranslateError: true,
 	})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
@@ -0,0 +1,474 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// errUserNotDeleted aborts restoring a user who is not deleted
+	errUserNotDeleted = errors.New("user is not deleted")
+	// errUserNotSuspended aborts reactivating a user who is not suspended
+	errUserNotSuspended = errors.New("user is not suspended")
+)
+
+// AdminHandler serves the admin-only endpoints. Routes must be guarded with
+// middleware.RequireRole(models.RoleAdmin).
+type AdminHandler struct {
+	auth      *AuthHandler
+	userRepo  models.UserRepository
+	taskRepo  models.TaskRepository
+	auditRepo models.AuditEventRepository
+}
+
+func NewAdminHandler(auth *AuthHandler, userRepo models.UserRepository, taskRepo models.TaskRepository, auditRepo models.AuditEventRepository) *AdminHandler {
+	return &AdminHandler{
+		auth:      auth,
+		userRepo:  userRepo,
+		taskRepo:  taskRepo,
+		auditRepo: auditRepo,
+	}
+}
+
+// AdminUserResponse shows a user with the account state hidden from the user
+// themselves
+type AdminUserResponse struct {
+	models.User
+	DeletedAt  *time.Time         `json:"deleted_at,omitempty"`
+	TaskCounts *models.TaskCounts `json:"task_counts,omitempty"`
+}
+
+func newAdminUserResponse(user *models.User) AdminUserResponse {
+	response := AdminUserResponse{User: *user}
+	if user.DeletedAt.Valid {
+		response.DeletedAt = &user.DeletedAt.Time
+	}
+	return response
+}
+
+// ListUsers searches users by name or email, optionally narrowed down by
+// status and role
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		Search: strings.TrimSpace(query.Get("search")),
+		Status: query.Get("status"),
+		Role:   query.Get("role"),
+		Page:   1,
+		Limit:  10,
+	}
+	if p, err := strconv.Atoi(query.Get("page")); err == nil && p > 0 {
+		filter.Page = p
+	}
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		filter.Limit = l
+	}
+
+	switch filter.Status {
+	case "", models.UserStatusActive, models.UserStatusSuspended, models.UserStatusDeleted:
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "Invalid status value")
+		return
+	}
+	if filter.Role != "" && !models.ValidRoles[filter.Role] {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid role value")
+		return
+	}
+
+	result, err := h.userRepo.ListUsers(filter)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+
+	users := make([]AdminUserResponse, len(result.Users))
+	for i := range result.Users {
+		users[i] = newAdminUserResponse(&result.Users[i])
+	}
+
+	utils.RespondSuccess(w, "Users fetched successfully", map[string]interface{}{
+		"users":       users,
+		"total":       result.Total,
+		"page":        result.Page,
+		"limit":       result.Limit,
+		"total_pages": result.TotalPages,
+	})
+}
+
+// GetUser returns any user, including soft-deleted ones, with their task
+// counts. The counts of a deleted user are of the tasks deleted with them.
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	var counts *models.TaskCounts
+	var err error
+	if user.DeletedAt.Valid {
+		counts, err = h.taskRepo.CountTasksDeletedWithUser(user.ID, user.DeletedAt.Time)
+	} else {
+		counts, err = h.taskRepo.CountTasksByUserID(user.ID)
+	}
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+
+	response := newAdminUserResponse(user)
+	response.TaskCounts = counts
+	utils.RespondSuccess(w, "User fetched successfully", response)
+}
+
+// SuspendUser blocks the user from logging in and signs them out
+func (h *AdminHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadOtherUser(w, r, "suspend")
+	if !ok {
+		return
+	}
+
+	err := h.recordAdminAction(r, models.AuditActionUserSuspend, user, nil, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).SuspendUser(user.ID)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to suspend user")
+		return
+	}
+
+	utils.RespondSuccess(w, "User suspended successfully", nil)
+}
+
+func (h *AdminHandler) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	if user.DeletedAt.Valid {
+		utils.RespondError(w, http.StatusConflict, "User is deleted")
+		return
+	}
+
+	err := h.recordAdminAction(r, models.AuditActionUserReactivate, user, nil, func(tx *gorm.DB) error {
+		reactivated, err := h.userRepo.WithTx(tx).ReactivateUser(user.ID)
+		if err == nil && !reactivated {
+			return errUserNotSuspended
+		}
+		return err
+	})
+	if err != nil {
+		if err == errUserNotSuspended {
+			utils.RespondError(w, http.StatusConflict, "User is not suspended")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to reactivate user")
+		return
+	}
+
+	utils.RespondSuccess(w, "User reactivated successfully", nil)
+}
+
+// RestoreUser brings back a soft-deleted user and the tasks deleted with them
+func (h *AdminHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.recordAdminAction(r, models.AuditActionUserRestore, user, nil, func(tx *gorm.DB) error {
+		restored, err := h.userRepo.WithTx(tx).RestoreUser(user.ID)
+		if err == nil && !restored {
+			return errUserNotDeleted
+		}
+		return err
+	})
+	if err != nil {
+		if err == errUserNotDeleted {
+			utils.RespondError(w, http.StatusConflict, "User is not deleted")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to restore user")
+		return
+	}
+
+	utils.RespondSuccess(w, "User restored successfully", nil)
+}
+
+// ForcePasswordReset signs the user out, blocks password logins and emails
+// them a reset link
+func (h *AdminHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	if user.DeletedAt.Valid {
+		utils.RespondError(w, http.StatusConflict, "User is deleted")
+		return
+	}
+
+	// The link is sent before the change is committed, so that a failed
+	// email leaves the account as it was
+	err := h.recordAdminAction(r, models.AuditActionPasswordResetForced, user, nil, func(tx *gorm.DB) error {
+		if err := h.userRepo.WithTx(tx).RequirePasswordReset(user.ID); err != nil {
+			return err
+		}
+		if err := h.auth.sendPasswordReset(h.auth.userTokenRepo.WithTx(tx), user); err != nil {
+			log.Printf("Failed to send password reset to user %d: %v", user.ID, err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to force password reset")
+		return
+	}
+
+	utils.RespondSuccess(w, "Password reset required, a reset link has been sent to the user", nil)
+}
+
+// PurgeUser permanently deletes the user and all their tasks
+func (h *AdminHandler) PurgeUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadOtherUser(w, r, "delete")
+	if !ok {
+		return
+	}
+
+	err := h.recordAdminAction(r, models.AuditActionUserPurge, user, map[string]string{"email": user.Email}, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).PurgeUser(user.ID)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+
+	utils.RespondSuccess(w, "User permanently deleted", nil)
+}
+
+// ImpersonateUser issues a short-lived access token for acting as the user,
+// e.g. to see what they see when investigating a support request. The token
+// names the admin, has no refresh token and cannot be used to change the
+// user's credentials.
+func (h *AdminHandler) ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	user, ok := h.loadOtherUser(w, r, "impersonate")
+	if !ok {
+		return
+	}
+	if user.DeletedAt.Valid {
+		utils.RespondError(w, http.StatusConflict, "User is deleted")
+		return
+	}
+	if user.IsSuspended() {
+		utils.RespondError(w, http.StatusConflict, "User is suspended")
+		return
+	}
+	if user.Role == models.RoleAdmin {
+		utils.RespondError(w, http.StatusForbidden, "Admins cannot be impersonated")
+		return
+	}
+
+	ttl := config.AppConfig.ImpersonationTTL
+	token, err := utils.IssueToken(utils.JWTClaims{
+		UserID:                   user.ID,
+		Email:                    user.Email,
+		Role:                     user.Role,
+		TokenVersion:             user.TokenVersion,
+		ImpersonatorID:           admin.ID,
+		ImpersonatorTokenVersion: admin.TokenVersion,
+	}, ttl)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	// Nothing is stored for the token, so only the event can fail here
+	if !h.auth.audit.recordOrFail(w, r, models.AuditActionImpersonate, &admin.ID, models.AuditTargetUser, &user.ID,
+		map[string]string{"expires_in": ttl.String()}) {
+		return
+	}
+	log.Printf("[impersonation] admin %d (%s) started impersonating user %d (%s)", admin.ID, admin.Email, user.ID, user.Email)
+
+	utils.RespondSuccess(w, "Impersonation token issued", AuthResponse{
+		Token:     token,
+		ExpiresIn: int64(ttl.Seconds()),
+		User:      newUserResponse(user),
+	})
+}
+
+// recordAdminAction makes a change to the user on behalf of the signed-in
+// admin and records it in the same transaction, see auditLog.recordWith
+func (h *AdminHandler) recordAdminAction(r *http.Request, action string, user *models.User, details interface{}, change func(tx *gorm.DB) error) error {
+	var adminID *uint
+	if userClaims, ok := middleware.GetUserFromContext(r.Context()); ok {
+		adminID = &userClaims.UserID
+	}
+	return h.auth.audit.recordWith(r, action, adminID, models.AuditTargetUser, &user.ID, details, change)
+}
+
+// loadUser finds the user in the URL, including soft-deleted users
+func (h *AdminHandler) loadUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return nil, false
+	}
+
+	user, err := h.userRepo.GetUserByIDUnscoped(uint(userID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "User not found")
+			return nil, false
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch user")
+		return nil, false
+	}
+	return user, true
+}
+
+// loadOtherUser is loadUser for actions an admin must not take on their own
+// account
+func (h *AdminHandler) loadOtherUser(w http.ResponseWriter, r *http.Request, action string) (*models.User, bool) {
+	admin, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return nil, false
+	}
+
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if user.ID == admin.ID {
+		utils.RespondError(w, http.StatusBadRequest, "You cannot "+action+" your own account")
+		return nil, false
+	}
+	return user, true
+}
+
+// ListUserTasks lists the tasks of any user, with the same filters as
+// GET /tasks
+func (h *AdminHandler) ListUserTasks(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if _, err := h.userRepo.GetUserByID(uint(userID)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+
+	filter, ok := parseTaskFilter(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := h.taskRepo.GetTasksByUserID(uint(userID), filter)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch tasks")
+		return
+	}
+
+	utils.RespondSuccess(w, "Tasks fetched successfully", result)
+}
+
+// GetTask returns any task regardless of its owner
+func (h *AdminHandler) GetTask(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid task ID")
+		return
+	}
+
+	task, err := h.taskRepo.GetTaskByID(uint(taskID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch task")
+		return
+	}
+
+	utils.RespondSuccess(w, "Task fetched successfully", task)
+}
+
+// ListAuditEvents searches the audit log, newest first. Times are RFC 3339;
+// until is exclusive.
+func (h *AdminHandler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditEventFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+	}
+	if p, err := strconv.Atoi(query.Get("page")); err == nil && p > 0 {
+		filter.Page = p
+	}
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		filter.Limit = l
+	}
+
+	for _, param := range []struct {
+		name  string
+		label string
+		dest  *uint
+	}{
+		{"actor_id", "actor ID", &filter.ActorUserID},
+		{"impersonator_id", "impersonator ID", &filter.ImpersonatorUserID},
+		{"target_id", "target ID", &filter.TargetID},
+	} {
+		if value := query.Get(param.name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || id == 0 {
+				utils.RespondError(w, http.StatusBadRequest, "Invalid "+param.label)
+				return
+			}
+			*param.dest = uint(id)
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if value := query.Get(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				utils.RespondError(w, http.StatusBadRequest, "Invalid "+param.name+" time, use RFC 3339")
+				return
+			}
+			*param.dest = t
+		}
+	}
+
+	result, err := h.auditRepo.ListAuditEvents(filter)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch audit events")
+		return
+	}
+
+	utils.RespondSuccess(w, "Audit events fetched successfully", result)
+}
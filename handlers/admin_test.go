@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"gorm.io/gorm"
+)
+
+type fakeUserRepo struct {
+	models.UserRepository
+	users map[uint]*models.User
+}
+
+func (f *fakeUserRepo) GetUserByIDUnscoped(id uint) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+type fakeAuditEventRepo struct {
+	models.AuditEventRepository
+	events []*models.AuditEvent
+}
+
+func (f *fakeAuditEventRepo) AppendAuditEvent(event *models.AuditEvent) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+func TestImpersonateUser(t *testing.T) {
+	config.AppConfig = &config.Config{JWTSecret: "test-secret-key-for-testing", ImpersonationTTL: 15 * time.Minute}
+	suspendedAt := time.Now()
+	admin := &models.User{ID: 1, Email: "admin@example.com", Role: models.RoleAdmin}
+
+	tests := []struct {
+		name       string
+		target     models.User
+		wantStatus int
+	}{
+		{"Active user", models.User{Role: models.RoleUser}, http.StatusOK},
+		{"Suspended user", models.User{Role: models.RoleUser, SuspendedAt: &suspendedAt}, http.StatusConflict},
+		{"Deleted user", models.User{Role: models.RoleUser, DeletedAt: gorm.DeletedAt{Time: suspendedAt, Valid: true}}, http.StatusConflict},
+		{"Admin", models.User{Role: models.RoleAdmin}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			target.ID, target.Email = 2, "user@example.com"
+			auditRepo := &fakeAuditEventRepo{}
+			h := &AdminHandler{
+				auth:     &AuthHandler{audit: newAuditLog(auditRepo)},
+				userRepo: &fakeUserRepo{users: map[uint]*models.User{admin.ID: admin, target.ID: &target}},
+			}
+
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("id", "2")
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, routeContext)
+			ctx = context.WithValue(ctx, middleware.CurrentUserContextKey, admin)
+			r := httptest.NewRequest(http.MethodPost, "/api/admin/users/2/impersonate", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			h.ImpersonateUser(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if recorded := len(auditRepo.events) > 0; recorded != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("impersonate event recorded = %v, want %v", recorded, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
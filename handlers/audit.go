@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+// auditLog appends events to the audit trail. A failed write of a data change
+// is logged but does not fail the request. Security actions instead use
+// recordWith, which stores the change and its event in one transaction so
+// that neither exists without the other, or recordOrFail when the action
+// stores nothing itself.
+type auditLog struct {
+	repo models.AuditEventRepository
+}
+
+func newAuditLog(repo models.AuditEventRepository) *auditLog {
+	return &auditLog{repo: repo}
+}
+
+// record stores an event about the target, performed by actorID (nil when
+// unknown, e.g. a failed login). details is encoded as JSON. Events of
+// requests made with an impersonation token also name the admin.
+func (a *auditLog) record(r *http.Request, action string, actorID *uint, targetType string, targetID *uint, details interface{}) {
+	if err := a.repo.AppendAuditEvent(newAuditEvent(r, action, actorID, targetType, targetID, details)); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// recordOrFail is record for security actions that store nothing of their
+// own, e.g. issuing a token. When the event cannot be stored it responds with
+// an error and returns false.
+func (a *auditLog) recordOrFail(w http.ResponseWriter, r *http.Request, action string, actorID *uint, targetType string, targetID *uint, details interface{}) bool {
+	if err := a.repo.AppendAuditEvent(newAuditEvent(r, action, actorID, targetType, targetID, details)); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to record audit event")
+		return false
+	}
+	return true
+}
+
+// recordWith makes a security change through repositories bound to tx and
+// records its event in the same transaction, so that a failed event also
+// undoes the change. targetID is read once change has run, so it may point
+// at the ID of a row change creates. The error of change is returned as is.
+func (a *auditLog) recordWith(r *http.Request, action string, actorID *uint, targetType string, targetID *uint, details interface{}, change func(tx *gorm.DB) error) error {
+	changed := false
+	err := a.repo.AppendAuditEventWith(newAuditEvent(r, action, actorID, targetType, targetID, details), func(tx *gorm.DB) error {
+		if err := change(tx); err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	})
+	if err != nil && changed {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+	return err
+}
+
+// recordUserWith is recordWith for an action the user performed on their own
+// account
+func (a *auditLog) recordUserWith(r *http.Request, action string, userID uint, details interface{}, change func(tx *gorm.DB) error) error {
+	return a.recordWith(r, action, &userID, models.AuditTargetUser, &userID, details, change)
+}
+
+// newAuditEvent builds the event of the request. details is encoded as JSON
+// and the admin of an impersonation token is named.
+func newAuditEvent(r *http.Request, action string, actorID *uint, targetType string, targetID *uint, details interface{}) *models.AuditEvent {
+	encoded := []byte("{}")
+	if details != nil {
+		var err error
+		if encoded, err = json.Marshal(details); err != nil {
+			log.Printf("Failed to encode audit details of %s: %v", action, err)
+			encoded = []byte("{}")
+		}
+	}
+
+	event := &models.AuditEvent{
+		Action:      action,
+		ActorUserID: actorID,
+		TargetType:  targetType,
+		TargetID:    targetID,
+		IPAddress:   clientIP(r),
+		RequestID:   chimiddleware.GetReqID(r.Context()),
+		Details:     string(encoded),
+	}
+	if claims, ok := middleware.GetUserFromContext(r.Context()); ok && claims.IsImpersonation() {
+		impersonatorID := claims.ImpersonatorID
+		event.ImpersonatorUserID = &impersonatorID
+	}
+	return event
+}
+
+// recordUser stores an event the user performed on their own account
+func (a *auditLog) recordUser(r *http.Request, action string, userID uint, details interface{}) {
+	a.record(r, action, &userID, models.AuditTargetUser, &userID, details)
+}
+
+// taskSnapshot holds the audited fields of a task
+type taskSnapshot struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Priority    string `json:"priority"`
+	DueAt       string `json:"due_at,omitempty"`
+	AllDay      bool   `json:"all_day,omitempty"`
+	Labels      string `json:"labels,omitempty"`
+	ParentID    string `json:"parent_id,omitempty"`
+}
+
+func newTaskSnapshot(task *models.Task) taskSnapshot {
+	snapshot := taskSnapshot{
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		Priority:    task.Priority.String(),
+		AllDay:      task.AllDay,
+	}
+	if task.DueAt != nil {
+		snapshot.DueAt = task.DueAt.UTC().Format(time.RFC3339)
+	}
+	labels := make([]string, len(task.Labels))
+	for i, label := range task.Labels {
+		labels[i] = label.Name
+	}
+	sort.Strings(labels)
+	snapshot.Labels = strings.Join(labels, ",")
+	if task.ParentID != nil {
+		snapshot.ParentID = strconv.FormatUint(uint64(*task.ParentID), 10)
+	}
+	return snapshot
+}
+
+// taskDiff lists the fields that differ between two versions of a task
+func taskDiff(before, after taskSnapshot) map[string]map[string]string {
+	beforeFields := map[string]string{}
+	afterFields := map[string]string{}
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{"title", before.Title, after.Title},
+		{"description", before.Description, after.Description},
+		{"status", before.Status, after.Status},
+		{"priority", before.Priority, after.Priority},
+		{"due_at", before.DueAt, after.DueAt},
+		{"all_day", strconv.FormatBool(before.AllDay), strconv.FormatBool(after.AllDay)},
+		{"labels", before.Labels, after.Labels},
+		{"parent_id", before.ParentID, after.ParentID},
+	} {
+		if field.old != field.new {
+			beforeFields[field.name] = field.old
+			afterFields[field.name] = field.new
+		}
+	}
+	return map[string]map[string]string{"before": beforeFields, "after": afterFields}
+}
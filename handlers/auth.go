@@ -2,21 +2,53 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/mailer"
+	"github.com/hrusfandi/sb-task-management/middleware"
 	"github.com/hrusfandi/sb-task-management/models"
 	"github.com/hrusfandi/sb-task-management/utils"
 	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
-	userRepo models.UserRepository
+	userRepo         models.UserRepository
+	refreshTokenRepo models.RefreshTokenRepository
+	revokedTokenRepo models.RevokedTokenRepository
+	userTokenRepo    models.UserTokenRepository
+	recoveryCodeRepo models.RecoveryCodeRepository
+	sessionRepo      models.SessionRepository
+	audit            *auditLog
+	mailer           mailer.Mailer
+	loginThrottle    *loginThrottle
 }
 
-func NewAuthHandler(userRepo models.UserRepository) *AuthHandler {
+func NewAuthHandler(
+	userRepo models.UserRepository,
+	refreshTokenRepo models.RefreshTokenRepository,
+	revokedTokenRepo models.RevokedTokenRepository,
+	userTokenRepo models.UserTokenRepository,
+	recoveryCodeRepo models.RecoveryCodeRepository,
+	sessionRepo models.SessionRepository,
+	loginAttemptRepo models.LoginAttemptRepository,
+	auditRepo models.AuditEventRepository,
+	mail mailer.Mailer,
+) *AuthHandler {
 	return &AuthHandler{
-		userRepo: userRepo,
+		userRepo:         userRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		revokedTokenRepo: revokedTokenRepo,
+		userTokenRepo:    userTokenRepo,
+		recoveryCodeRepo: recoveryCodeRepo,
+		sessionRepo:      sessionRepo,
+		audit:            newAuditLog(auditRepo),
+		mailer:           mail,
+		loginThrottle:    newLoginThrottle(loginAttemptRepo),
 	}
 }
 
@@ -29,11 +61,25 @@ type RegisterRequest struct {
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// UseCookies delivers the tokens as HttpOnly cookies instead of in the
+	// response body
+	UseCookies bool `json:"use_cookies"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token,omitempty"`
+	RefreshToken string      `json:"refresh_token,omitempty"`
+	CSRFToken    string      `json:"csrf_token,omitempty"`
+	ExpiresIn    int64       `json:"expires_in"`
+	User         models.User `json:"user"`
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -43,30 +89,29 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req.Name = strings.TrimSpace(req.Name)
-	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+	req.Name = utils.NormalizeName(req.Name)
+	req.Email = utils.NormalizeEmail(req.Email)
 	req.Password = strings.TrimSpace(req.Password)
 
-	// Validate name
+	var violations []string
 	if valid, msg := utils.ValidateName(req.Name); !valid {
-		utils.RespondError(w, http.StatusBadRequest, msg)
-		return
+		violations = append(violations, msg)
 	}
-
-	// Validate email
 	if !utils.ValidateEmail(req.Email) {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid email format")
+		violations = append(violations, "Invalid email format")
+	}
+	violations = append(violations, utils.CheckPassword(req.Password, req.Name, req.Email)...)
+	if len(violations) > 0 {
+		utils.RespondValidationErrors(w, violations)
 		return
 	}
 
-	// Validate password
-	if valid, msg := utils.ValidatePassword(req.Password); !valid {
-		utils.RespondError(w, http.StatusBadRequest, msg)
+	taken, err := h.userRepo.IsEmailTaken(req.Email)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
-
-	existingUser, _ := h.userRepo.GetUserByEmail(req.Email)
-	if existingUser != nil {
+	if taken {
 		utils.RespondError(w, http.StatusConflict, "Email already registered")
 		return
 	}
@@ -84,19 +129,21 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.userRepo.CreateUser(user); err != nil {
+		if err == models.ErrEmailTaken {
+			utils.RespondError(w, http.StatusConflict, "Email already registered")
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
 
-	userResponse := models.User{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+	h.audit.recordUser(r, models.AuditActionRegister, user.ID, map[string]string{"email": user.Email})
+
+	if err := h.sendEmailVerification(user); err != nil {
+		log.Printf("Failed to send email verification to user %d: %v", user.ID, err)
 	}
 
-	utils.RespondCreated(w, "User registered successfully", userResponse)
+	utils.RespondCreated(w, "User registered successfully", newUserResponse(user))
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -106,7 +153,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+	req.Email = utils.NormalizeEmail(req.Email)
 	req.Password = strings.TrimSpace(req.Password)
 
 	if req.Email == "" || req.Password == "" {
@@ -114,10 +161,22 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	wait, err := h.loginThrottle.retryAfter(req.Email, ip)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		utils.RespondError(w, http.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+		return
+	}
+
 	user, err := h.userRepo.GetUserByEmail(req.Email)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			utils.RespondError(w, http.StatusUnauthorized, "Invalid credentials")
+			h.respondLoginFailure(w, r, req.Email)
 			return
 		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
@@ -125,28 +184,287 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := utils.ComparePassword(user.Password, req.Password); err != nil {
-		utils.RespondError(w, http.StatusUnauthorized, "Invalid credentials")
+		h.respondLoginFailure(w, r, req.Email)
 		return
 	}
 
-	token, err := utils.GenerateToken(user.ID, user.Email)
+	h.upgradePasswordHash(user, req.Password)
+
+	if user.IsSuspended() {
+		utils.RespondError(w, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if user.PasswordResetRequired {
+		utils.RespondError(w, http.StatusForbidden, "Password reset required, use the link sent to your email")
+		return
+	}
+
+	if config.AppConfig.EmailVerificationMode == config.EmailVerificationLogin && user.EmailVerifiedAt == nil {
+		utils.RespondError(w, http.StatusForbidden, "Email address has not been verified")
+		return
+	}
+
+	// The failures of the email are only forgotten once the second factor
+	// has passed too, so that a known password does not reset the lockout
+	// for guessing codes
+	if user.TOTPEnabledAt != nil {
+		h.respondMFAChallenge(w, user)
+		return
+	}
+
+	if err := h.loginThrottle.succeed(req.Email); err != nil {
+		log.Printf("Failed to clear login attempts of user %d: %v", user.ID, err)
+	}
+
+	response, err := h.issueTokens(r, user, "")
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	userResponse := models.User{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+	h.audit.recordUser(r, models.AuditActionLogin, user.ID, map[string]string{"method": "password"})
+	respondTokens(w, "Login successful", response, req.UseCookies)
+}
+
+// upgradePasswordHash rehashes the password that was just verified when its
+// stored hash uses an outdated algorithm or weaker parameters. Failures are
+// only logged; the old hash keeps working.
+func (h *AuthHandler) upgradePasswordHash(user *models.User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+
+	newHash, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	if err := h.userRepo.RehashPassword(user.ID, user.Password, newHash); err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = newHash
+}
+
+// respondLoginFailure counts a failed login, for unknown emails too so that
+// lockouts do not reveal which accounts exist
+func (h *AuthHandler) respondLoginFailure(w http.ResponseWriter, r *http.Request, email string) {
+	h.audit.record(r, models.AuditActionLoginFailed, nil, "", nil, map[string]string{"method": "password", "email": email})
+
+	wait, err := h.loginThrottle.fail(email, clientIP(r))
+	if err != nil {
+		log.Printf("Failed to record failed login: %v", err)
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+	}
+	utils.RespondError(w, http.StatusUnauthorized, "Invalid credentials")
+}
+
+func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
 	}
 
-	response := AuthResponse{
-		Token: token,
-		User:  userResponse,
+	// Cookie logins refresh with the cookie, which the browser would also
+	// send on a forged request
+	useCookies := false
+	req.RefreshToken = strings.TrimSpace(req.RefreshToken)
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(refreshTokenCookie); err == nil && cookie.Value != "" {
+			if !middleware.ValidCSRFToken(r) {
+				utils.RespondError(w, http.StatusForbidden, "Invalid CSRF token")
+				return
+			}
+			req.RefreshToken = cookie.Value
+			useCookies = true
+		}
+	}
+	if req.RefreshToken == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Refresh token is required")
+		return
 	}
 
-	utils.RespondSuccess(w, "Login successful", response)
-}
\ No newline at end of file
+	stored, err := h.refreshTokenRepo.GetRefreshTokenByHash(utils.HashToken(req.RefreshToken))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to refresh token")
+		return
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	// A rotated token being presented again means it has leaked: kill the
+	// whole family so that neither the attacker nor the victim can continue.
+	marked, err := h.refreshTokenRepo.MarkRefreshTokenUsed(stored.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to refresh token")
+		return
+	}
+	if !marked {
+		if err := h.refreshTokenRepo.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to refresh token")
+			return
+		}
+		utils.RespondError(w, http.StatusUnauthorized, "Refresh token reuse detected")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(stored.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to refresh token")
+		return
+	}
+
+	response, err := h.issueTokens(r, user, stored.FamilyID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondTokens(w, "Token refreshed successfully", response, useCookies)
+}
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// The body is optional; when a refresh token is supplied its family is
+	// revoked as well so the client cannot silently obtain a new access token.
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	if err := h.revokedTokenRepo.RevokeToken(userClaims.ID, userClaims.UserID, userClaims.ExpiresAt.Time); err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to logout")
+		return
+	}
+
+	if userClaims.SessionID != 0 {
+		if _, err := h.sessionRepo.RevokeSession(userClaims.SessionID, userClaims.UserID); err != nil && err != gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to logout")
+			return
+		}
+	}
+
+	if refreshToken := strings.TrimSpace(req.RefreshToken); refreshToken != "" {
+		stored, err := h.refreshTokenRepo.GetRefreshTokenByHash(utils.HashToken(refreshToken))
+		if err != nil && err != gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to logout")
+			return
+		}
+		if stored != nil && stored.UserID == userClaims.UserID {
+			if err := h.refreshTokenRepo.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+				utils.RespondError(w, http.StatusInternalServerError, "Failed to logout")
+				return
+			}
+		}
+	}
+
+	clearSessionCookies(w)
+	utils.RespondSuccess(w, "Logout successful", nil)
+}
+
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	err := h.audit.recordUserWith(r, models.AuditActionLogoutAll, userClaims.UserID, nil, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).RevokeAllTokens(userClaims.UserID)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to logout")
+		return
+	}
+
+	clearSessionCookies(w)
+	utils.RespondSuccess(w, "Logged out from all devices", nil)
+}
+
+// issueTokens creates an access token and a refresh token for the user. An
+// empty familyID starts a new refresh token family and with it a new session;
+// otherwise the session of the family is carried over.
+func (h *AuthHandler) issueTokens(r *http.Request, user *models.User, familyID string) (*AuthResponse, error) {
+	jti, err := utils.GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.startOrRotateSession(r, user.ID, familyID, jti)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.IssueToken(utils.JWTClaims{
+		UserID:       user.ID,
+		Email:        user.Email,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		SessionID:    session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID: jti,
+		},
+	}, utils.AccessTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.refreshTokenRepo.CreateRefreshToken(&models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  session.FamilyID,
+		ExpiresAt: time.Now().Add(config.AppConfig.RefreshTokenTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL().Seconds()),
+		User:         newUserResponse(user),
+	}, nil
+}
+
+// newUserResponse copies the fields of a user that are safe to return
+func newUserResponse(user *models.User) models.User {
+	return models.User{
+		ID:              user.ID,
+		Name:            user.Name,
+		Email:           user.Email,
+		Role:            user.Role,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PendingEmail:    user.PendingEmail,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type fakeRefreshTokenRepo struct {
+	models.RefreshTokenRepository
+	tokens          map[string]*models.RefreshToken
+	revokedFamilies []string
+}
+
+func (f *fakeRefreshTokenRepo) GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error) {
+	token, ok := f.tokens[tokenHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (f *fakeRefreshTokenRepo) MarkRefreshTokenUsed(id uint) (bool, error) {
+	for _, token := range f.tokens {
+		if token.ID == id && token.UsedAt == nil && token.RevokedAt == nil {
+			now := time.Now()
+			token.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRefreshTokenRepo) RevokeRefreshTokenFamily(familyID string) error {
+	f.revokedFamilies = append(f.revokedFamilies, familyID)
+	now := time.Now()
+	for _, token := range f.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func TestRefreshTokenRejected(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name              string
+		token             models.RefreshToken
+		wantError         string
+		wantFamilyRevoked bool
+	}{
+		{
+			name:              "Reused token revokes the family",
+			token:             models.RefreshToken{UsedAt: &now, ExpiresAt: now.Add(time.Hour)},
+			wantError:         "Refresh token reuse detected",
+			wantFamilyRevoked: true,
+		},
+		{
+			name:      "Revoked token",
+			token:     models.RefreshToken{RevokedAt: &now, ExpiresAt: now.Add(time.Hour)},
+			wantError: "Invalid refresh token",
+		},
+		{
+			name:      "Expired token",
+			token:     models.RefreshToken{ExpiresAt: now.Add(-time.Minute)},
+			wantError: "Invalid refresh token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := tt.token
+			stored.ID, stored.UserID, stored.FamilyID = 1, 1, "family"
+			sibling := models.RefreshToken{ID: 2, UserID: 1, FamilyID: "family", ExpiresAt: now.Add(time.Hour)}
+			repo := &fakeRefreshTokenRepo{tokens: map[string]*models.RefreshToken{
+				utils.HashToken("presented"): &stored,
+				utils.HashToken("latest"):    &sibling,
+			}}
+			h := &AuthHandler{refreshTokenRepo: repo}
+
+			w := httptest.NewRecorder()
+			body := strings.NewReader(`{"refresh_token":"presented"}`)
+			h.RefreshToken(w, httptest.NewRequest(http.MethodPost, "/api/token/refresh", body))
+
+			if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("response = %d %s, want %d %q", w.Code, w.Body.String(), http.StatusUnauthorized, tt.wantError)
+			}
+			if familyRevoked := len(repo.revokedFamilies) > 0; familyRevoked != tt.wantFamilyRevoked {
+				t.Errorf("family revoked = %v, want %v", familyRevoked, tt.wantFamilyRevoked)
+			}
+			// The newest token of the family dies with the reused one
+			if latestRevoked := sibling.RevokedAt != nil; latestRevoked != tt.wantFamilyRevoked {
+				t.Errorf("latest token revoked = %v, want %v", latestRevoked, tt.wantFamilyRevoked)
+			}
+		})
+	}
+}
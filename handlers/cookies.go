@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/utils"
+)
+
+const refreshTokenCookie = "refresh_token"
+
+// respondTokens answers a completed login or refresh. In cookie mode the
+// tokens are set as HttpOnly cookies, together with a fresh CSRF token, and
+// left out of the body so that scripts never see them.
+func respondTokens(w http.ResponseWriter, message string, response *AuthResponse, useCookies bool) {
+	if useCookies {
+		csrfToken, err := utils.GenerateSecureToken()
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		refreshTTL := config.AppConfig.RefreshTokenTTL
+		setSessionCookie(w, middleware.AccessTokenCookie, response.Token, utils.AccessTokenTTL(), true)
+		setSessionCookie(w, refreshTokenCookie, response.RefreshToken, refreshTTL, true)
+		setSessionCookie(w, middleware.CSRFCookie, csrfToken, refreshTTL, false)
+
+		response.Token = ""
+		response.RefreshToken = ""
+		response.CSRFToken = csrfToken
+	}
+
+	utils.RespondSuccess(w, message, response)
+}
+
+// clearSessionCookies ends a cookie login in the browser
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{middleware.AccessTokenCookie, refreshTokenCookie} {
+		setSessionCookie(w, name, "", -1, true)
+	}
+	setSessionCookie(w, middleware.CSRFCookie, "", -1, false)
+}
+
+// setSessionCookie sets a cookie of a cookie login; a negative maxAge
+// deletes it
+func setSessionCookie(w http.ResponseWriter, name, value string, maxAge time.Duration, httpOnly bool) {
+	cfg := config.AppConfig
+
+	sameSite := http.SameSiteStrictMode
+	switch cfg.SessionCookieSameSite {
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	seconds := int(maxAge.Seconds())
+	if maxAge < 0 {
+		seconds = -1
+	}
+
+	// Only the refresh endpoint needs the refresh token
+	path := "/"
+	if name == refreshTokenCookie {
+		path = "/api/token/refresh"
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   cfg.SessionCookieDomain,
+		MaxAge:   seconds,
+		HttpOnly: httpOnly,
+		Secure:   cfg.SessionCookieSecure,
+		SameSite: sameSite,
+	})
+}
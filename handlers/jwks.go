@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/hrusfandi/sb-task-management/utils"
+)
+
+type JWKSHandler struct {
+	keyring *utils.Keyring
+}
+
+func NewJWKSHandler(keyring *utils.Keyring) *JWKSHandler {
+	return &JWKSHandler{
+		keyring: keyring,
+	}
+}
+
+type JWKSResponse struct {
+	Keys []utils.JWK `json:"keys"`
+}
+
+// GetJWKS publishes the public signing keys in the standard JWKS format, so it
+// is not wrapped in the usual response envelope. Shared HS256 secrets are
+// never published, leaving the key set empty.
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.RespondJSON(w, http.StatusOK, JWKSResponse{Keys: h.keyring.PublicKeys()})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type LabelHandler struct {
+	labelRepo models.LabelRepository
+}
+
+func NewLabelHandler(labelRepo models.LabelRepository) *LabelHandler {
+	return &LabelHandler{
+		labelRepo: labelRepo,
+	}
+}
+
+type CreateLabelRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// UpdateLabelRequest changes the fields that are set
+type UpdateLabelRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+func (h *LabelHandler) CreateLabel(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req CreateLabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if valid, msg := utils.ValidateLabelName(req.Name); !valid {
+		utils.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if req.Color == "" {
+		req.Color = models.DefaultLabelColor
+	}
+	if !utils.ValidateLabelColor(req.Color) {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid color, use a hex color such as #1e90ff")
+		return
+	}
+
+	label := &models.Label{
+		UserID: userClaims.UserID,
+		Name:   utils.NormalizeName(req.Name),
+		Color:  strings.ToLower(req.Color),
+	}
+	if err := h.labelRepo.CreateLabel(label); err != nil {
+		if errors.Is(err, models.ErrLabelNameTaken) {
+			utils.RespondError(w, http.StatusConflict, "A label with this name already exists")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to create label")
+		return
+	}
+
+	utils.RespondCreated(w, "Label created successfully", label)
+}
+
+func (h *LabelHandler) ListLabels(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	labels, err := h.labelRepo.GetLabelsByUserID(userClaims.UserID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch labels")
+		return
+	}
+
+	utils.RespondSuccess(w, "Labels fetched successfully", labels)
+}
+
+func (h *LabelHandler) GetLabel(w http.ResponseWriter, r *http.Request) {
+	label, ok := h.findLabel(w, r)
+	if !ok {
+		return
+	}
+
+	utils.RespondSuccess(w, "Label fetched successfully", label)
+}
+
+func (h *LabelHandler) UpdateLabel(w http.ResponseWriter, r *http.Request) {
+	label, ok := h.findLabel(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateLabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Name != "" {
+		if valid, msg := utils.ValidateLabelName(req.Name); !valid {
+			utils.RespondError(w, http.StatusBadRequest, msg)
+			return
+		}
+		label.Name = utils.NormalizeName(req.Name)
+	}
+
+	if req.Color != "" {
+		if !utils.ValidateLabelColor(req.Color) {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid color, use a hex color such as #1e90ff")
+			return
+		}
+		label.Color = strings.ToLower(req.Color)
+	}
+
+	if err := h.labelRepo.UpdateLabel(label); err != nil {
+		if errors.Is(err, models.ErrLabelNameTaken) {
+			utils.RespondError(w, http.StatusConflict, "A label with this name already exists")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to update label")
+		return
+	}
+
+	utils.RespondSuccess(w, "Label updated successfully", label)
+}
+
+// DeleteLabel deletes the label and removes it from the tasks that have it
+func (h *LabelHandler) DeleteLabel(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	labelID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid label ID")
+		return
+	}
+
+	deleted, err := h.labelRepo.DeleteLabel(uint(labelID), userClaims.UserID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to delete label")
+		return
+	}
+	if !deleted {
+		utils.RespondError(w, http.StatusNotFound, "Label not found")
+		return
+	}
+
+	utils.RespondSuccess(w, "Label deleted successfully", nil)
+}
+
+// findLabel loads the label named by the URL, responding with an error and
+// returning false when the user has no such label
+func (h *LabelHandler) findLabel(w http.ResponseWriter, r *http.Request) (*models.Label, bool) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return nil, false
+	}
+
+	labelID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid label ID")
+		return nil, false
+	}
+
+	label, err := h.labelRepo.GetLabelByID(uint(labelID), userClaims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "Label not found")
+			return nil, false
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch label")
+		return nil, false
+	}
+	return label, true
+}
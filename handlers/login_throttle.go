@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/models"
+)
+
+// loginThrottle slows down and eventually locks out password guessing. Each
+// failed login for an email doubles the wait before the next attempt; after
+// LoginMaxFailures the email is locked for LoginLockoutDuration. Client IPs
+// are only locked out, with a higher limit, to stop one address spraying
+// passwords across many accounts.
+type loginThrottle struct {
+	repo models.LoginAttemptRepository
+}
+
+func newLoginThrottle(repo models.LoginAttemptRepository) *loginThrottle {
+	return &loginThrottle{repo: repo}
+}
+
+// retryAfter returns how long the client has to wait before it may try to
+// log in again, or zero if the attempt may proceed
+func (t *loginThrottle) retryAfter(email, ip string) (time.Duration, error) {
+	attempts, err := t.repo.GetLoginAttempts(models.LoginAttemptKeyEmail+email, models.LoginAttemptKeyIP+ip)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	now := time.Now()
+	for _, attempt := range attempts {
+		if d := blockedUntil(attempt).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// fail records a failed login and returns the wait imposed on the next one
+func (t *loginThrottle) fail(email, ip string) (time.Duration, error) {
+	window := config.AppConfig.LoginLockoutDuration
+
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range []string{models.LoginAttemptKeyEmail + email, models.LoginAttemptKeyIP + ip} {
+		attempt, err := t.repo.RecordLoginFailure(key, window)
+		if err != nil {
+			return 0, err
+		}
+		if d := blockedUntil(*attempt).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// succeed forgets the failures of an email. The IP counter is kept so that
+// an attacker cannot reset it by logging into an account of their own.
+func (t *loginThrottle) succeed(email string) error {
+	_, err := t.repo.ClearLoginAttempts(models.LoginAttemptKeyEmail + email)
+	return err
+}
+
+// throttleRequest limits requests for a key to one per interval. It returns
+// how long the client has to wait, or zero after recording the request as
+// accepted.
+func (t *loginThrottle) throttleRequest(key string, interval time.Duration) (time.Duration, error) {
+	attempts, err := t.repo.GetLoginAttempts(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(attempts) > 0 {
+		if wait := time.Until(attempts[0].LastFailedAt.Add(interval)); wait > 0 {
+			return wait, nil
+		}
+	}
+	_, err = t.repo.RecordLoginFailure(key, interval)
+	return 0, err
+}
+
+// blockedUntil computes when the next login for the attempt's key is allowed
+func blockedUntil(attempt models.LoginAttempt) time.Time {
+	cfg := config.AppConfig
+
+	if strings.HasPrefix(attempt.Key, models.LoginAttemptKeyIP) {
+		if cfg.LoginMaxFailuresPerIP == 0 || attempt.Failures < cfg.LoginMaxFailuresPerIP {
+			return time.Time{}
+		}
+		return attempt.LastFailedAt.Add(cfg.LoginLockoutDuration)
+	}
+
+	if cfg.LoginMaxFailures > 0 && attempt.Failures >= cfg.LoginMaxFailures {
+		return attempt.LastFailedAt.Add(cfg.LoginLockoutDuration)
+	}
+
+	// 1x, 2x, 4x... the base delay, never longer than a lockout
+	delay := cfg.LoginDelayBase
+	for i := 1; i < attempt.Failures && delay < cfg.LoginLockoutDuration; i++ {
+		delay *= 2
+	}
+	if delay > cfg.LoginLockoutDuration {
+		delay = cfg.LoginLockoutDuration
+	}
+	return attempt.LastFailedAt.Add(delay)
+}
+
+// clientIP returns the address set by middleware.RealIP, without the port
+// that RemoteAddr carries when no proxy header was present
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
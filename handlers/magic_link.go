@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/mailer"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyMagicLinkRequest carries the token of an emailed link, which opens a
+// page of the frontend that posts it here
+type VerifyMagicLinkRequest struct {
+	Token      string `json:"token"`
+	UseCookies bool   `json:"use_cookies"`
+}
+
+// RequestMagicLink emails a single-use sign-in link. It is only available
+// when MAGIC_LINK_ENABLED is set.
+func (h *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !config.AppConfig.MagicLinkEnabled {
+		utils.RespondError(w, http.StatusNotFound, "Magic link login is not enabled")
+		return
+	}
+
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Email = utils.NormalizeEmail(req.Email)
+	if !utils.ValidateEmail(req.Email) {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid email format")
+		return
+	}
+
+	// Registered and unknown emails are throttled and answered alike, so
+	// that the endpoint does not reveal which accounts exist
+	const message = "If the email is registered, a sign-in link has been sent"
+
+	wait, err := h.loginThrottle.throttleRequest(models.LoginAttemptKeyMagicLink+req.Email, config.AppConfig.MagicLinkRequestInterval)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		utils.RespondError(w, http.StatusTooManyRequests, "Please wait before requesting another sign-in link")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByEmail(req.Email)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondSuccess(w, message, nil)
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	if !user.IsSuspended() {
+		sendInBackground("magic link", user.ID, func() error {
+			return h.sendMagicLink(user)
+		})
+	}
+
+	utils.RespondSuccess(w, message, nil)
+}
+
+// VerifyMagicLink logs the user in with the token from an emailed link. It
+// only accepts POST, so that link scanners and mail clients prefetching the
+// link cannot use the token up.
+func (h *AuthHandler) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !config.AppConfig.MagicLinkEnabled {
+		utils.RespondError(w, http.StatusNotFound, "Magic link login is not enabled")
+		return
+	}
+
+	var req VerifyMagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	tokenString := strings.TrimSpace(req.Token)
+	if tokenString == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Sign-in token is required")
+		return
+	}
+
+	token, err := h.userTokenRepo.ConsumeUserToken(utils.HashToken(tokenString), models.UserTokenPurposeMagicLink)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired sign-in link")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(token.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired sign-in link")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if user.IsSuspended() {
+		utils.RespondError(w, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if user.PasswordResetRequired {
+		utils.RespondError(w, http.StatusForbidden, "Password reset required, use the link sent to your email")
+		return
+	}
+
+	// Opening the link proves control of the address
+	if user.EmailVerifiedAt == nil {
+		if err := h.userRepo.MarkEmailVerified(user.ID); err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+			return
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	// The link only stands in for the password, not for the second factor
+	if user.TOTPEnabledAt != nil {
+		h.respondMFAChallenge(w, user)
+		return
+	}
+
+	response, err := h.issueTokens(r, user, "")
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.audit.recordUser(r, models.AuditActionLogin, user.ID, map[string]string{"method": "magic_link"})
+	respondTokens(w, "Login successful", response, req.UseCookies)
+}
+
+// sendMagicLink replaces any outstanding sign-in link of the user with a new
+// one and emails it
+func (h *AuthHandler) sendMagicLink(user *models.User) error {
+	if err := h.userTokenRepo.InvalidateUserTokens(user.ID, models.UserTokenPurposeMagicLink); err != nil {
+		return err
+	}
+
+	token, err := h.createUserToken(h.userTokenRepo, user.ID, models.UserTokenPurposeMagicLink, config.AppConfig.MagicLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	link := config.AppConfig.AppBaseURL + "/magic-link?token=" + url.QueryEscape(token)
+	return h.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to sign in:\n\n%s\n\nThe link can be used once and expires in %s. If you did not request it, you can ignore this email.\n",
+			user.Name, link, config.AppConfig.MagicLinkTTL),
+	})
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	// totpSkew accepts codes from one step before or after the current one
+	totpSkew = 1
+)
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	UseCookies   bool   `json:"use_cookies"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type DisableTOTPRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginMFA completes a login with the second factor. Wrong codes count as
+// failed logins of the account and the client IP, so they share the delays
+// and lockouts of wrong passwords however many challenges are requested.
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req LoginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		utils.RespondError(w, http.StatusBadRequest, "MFA token and code are required")
+		return
+	}
+
+	claims, err := utils.ValidateToken(req.MFAToken)
+	if err != nil || claims.Purpose != utils.TokenPurposeMFA {
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	revoked, err := h.revokedTokenRepo.IsTokenRevoked(claims.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if revoked {
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if user.TokenVersion != claims.TokenVersion || user.TOTPEnabledAt == nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	ip := clientIP(r)
+	wait, err := h.loginThrottle.retryAfter(user.Email, ip)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		utils.RespondError(w, http.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+		return
+	}
+
+	ok, err := h.verifySecondFactor(user, req.Code, req.RecoveryCode)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if !ok {
+		h.audit.recordUser(r, models.AuditActionLoginFailed, user.ID, map[string]string{"method": "mfa"})
+		wait, err := h.loginThrottle.fail(user.Email, ip)
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+			return
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	if err := h.loginThrottle.succeed(user.Email); err != nil {
+		log.Printf("Failed to clear login attempts of user %d: %v", user.ID, err)
+	}
+
+	// The challenge token is single-use
+	if err := h.revokedTokenRepo.RevokeToken(claims.ID, user.ID, claims.ExpiresAt.Time); err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+
+	response, err := h.issueTokens(r, user, "")
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.audit.recordUser(r, models.AuditActionLogin, user.ID, map[string]string{"method": "mfa"})
+	respondTokens(w, "Login successful", response, req.UseCookies)
+}
+
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		utils.RespondError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	if err := h.userRepo.SetTOTPSecret(user.ID, secret); err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	utils.RespondSuccess(w, "Scan the URI with your authenticator app and confirm with a code", TOTPEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPProvisioningURI(config.AppConfig.MFAIssuer, user.Email, secret),
+	})
+}
+
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		utils.RespondError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Two-factor enrollment has not been started")
+		return
+	}
+
+	step, valid := utils.ValidateTOTPCode(user.TOTPSecret, req.Code, time.Now(), totpSkew)
+	if !valid {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+	fresh, err := h.userRepo.UseTOTPStep(user.ID, step)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	if !fresh {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	err = h.audit.recordUserWith(r, models.AuditActionTOTPEnable, user.ID, nil, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).EnableTOTP(user.ID, hashes)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	utils.RespondSuccess(w, "Two-factor authentication enabled, store the recovery codes somewhere safe", RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
+
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req DisableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		utils.RespondError(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	// Accounts with a password confirm it as well as a code. For those
+	// without one, reauthenticate would only ask for the code checked below.
+	if user.Password != "" && !h.reauthenticate(w, r, user, req.Password, "") {
+		return
+	}
+	if !h.checkThrottled(w, r, user, true, "Invalid code", func() (bool, error) {
+		return h.verifySecondFactor(user, req.Code, req.RecoveryCode)
+	}) {
+		return
+	}
+
+	err := h.audit.recordUserWith(r, models.AuditActionTOTPDisable, user.ID, nil, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).DisableTOTP(user.ID)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	utils.RespondSuccess(w, "Two-factor authentication disabled", nil)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		utils.RespondError(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	if !h.checkThrottled(w, r, user, true, "Invalid code", func() (bool, error) {
+		return h.verifySecondFactor(user, req.Code, "")
+	}) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to regenerate recovery codes")
+		return
+	}
+
+	err = h.audit.recordUserWith(r, models.AuditActionRecoveryCodesRenew, user.ID, nil, func(tx *gorm.DB) error {
+		return h.recoveryCodeRepo.WithTx(tx).ReplaceRecoveryCodes(user.ID, hashes)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to regenerate recovery codes")
+		return
+	}
+
+	utils.RespondSuccess(w, "Recovery codes regenerated", RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// respondMFAChallenge answers the first login step of a user with TOTP
+// enrolled with a short-lived token to be exchanged at /login/mfa
+func (h *AuthHandler) respondMFAChallenge(w http.ResponseWriter, user *models.User) {
+	ttl := config.AppConfig.MFAChallengeTTL
+	token, err := utils.IssueToken(utils.JWTClaims{
+		UserID:       user.ID,
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
+		Purpose:      utils.TokenPurposeMFA,
+	}, ttl)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	utils.RespondSuccess(w, "Two-factor authentication required", MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(ttl.Seconds()),
+	})
+}
+
+// verifySecondFactor accepts either a current TOTP code that has not been
+// used before or an unused recovery code
+func (h *AuthHandler) verifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if code = strings.TrimSpace(code); code != "" {
+		step, valid := utils.ValidateTOTPCode(user.TOTPSecret, code, time.Now(), totpSkew)
+		if !valid {
+			return false, nil
+		}
+		return h.userRepo.UseTOTPStep(user.ID, step)
+	}
+
+	if recoveryCode = utils.NormalizeRecoveryCode(recoveryCode); recoveryCode != "" {
+		return h.recoveryCodeRepo.ConsumeRecoveryCode(user.ID, utils.HashToken(recoveryCode))
+	}
+
+	return false, nil
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/oidc"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+
+	// tokenPurposeOIDCState marks the signed login state so that it can never
+	// be accepted as an access token
+	tokenPurposeOIDCState = "oidc_state"
+)
+
+var (
+	errOIDCEmailNotVerified = errors.New("identity provider did not verify the email address")
+	errOIDCNoAccount        = errors.New("no account is linked to this identity")
+)
+
+// oidcState is kept in a signed cookie between the redirect to the identity
+// provider and the callback
+type oidcState struct {
+	Purpose      string `json:"purpose"`
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	jwt.RegisteredClaims
+}
+
+type OIDCHandler struct {
+	auth         *AuthHandler
+	identityRepo models.UserIdentityRepository
+	providers    map[string]*oidc.Client
+}
+
+func NewOIDCHandler(auth *AuthHandler, identityRepo models.UserIdentityRepository, providers map[string]*oidc.Client) *OIDCHandler {
+	return &OIDCHandler{
+		auth:         auth,
+		identityRepo: identityRepo,
+		providers:    providers,
+	}
+}
+
+// Login redirects the browser to the identity provider
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	providerName := chi.URLParam(r, "provider")
+	provider, ok := h.providers[providerName]
+	if !ok {
+		utils.RespondError(w, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	state, err1 := oidc.GenerateRandomString()
+	nonce, err2 := oidc.GenerateRandomString()
+	verifier, err3 := oidc.GenerateRandomString()
+	if err1 != nil || err2 != nil || err3 != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", providerName, err)
+		utils.RespondError(w, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+
+	cookie, err := utils.SignClaims(oidcState{
+		Purpose:      tokenPurposeOIDCState,
+		Provider:     providerName,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.OIDCStateTTL)),
+		},
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	setOIDCStateCookie(w, cookie, int(config.OIDCStateTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback completes the authorization code flow and logs the user in
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	providerName := chi.URLParam(r, "provider")
+	provider, ok := h.providers[providerName]
+	if !ok {
+		utils.RespondError(w, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	// The state is single-use whatever the outcome
+	setOIDCStateCookie(w, "", -1)
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		utils.RespondError(w, http.StatusUnauthorized, "Login was denied by the identity provider")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Login session not found or expired")
+		return
+	}
+
+	var state oidcState
+	if err := utils.ParseClaims(cookie.Value, &state); err != nil || state.Purpose != tokenPurposeOIDCState {
+		utils.RespondError(w, http.StatusBadRequest, "Login session not found or expired")
+		return
+	}
+	if state.Provider != providerName || subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Authorization code is required")
+		return
+	}
+
+	token, err := provider.Exchange(r.Context(), code, state.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", providerName, err)
+		utils.RespondError(w, http.StatusUnauthorized, "Failed to authenticate with identity provider")
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(r.Context(), token.IDToken, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC ID token from %s rejected: %v", providerName, err)
+		utils.RespondError(w, http.StatusUnauthorized, "Failed to authenticate with identity provider")
+		return
+	}
+
+	user, err := h.resolveUser(providerName, claims)
+	if err != nil {
+		switch err {
+		case errOIDCEmailNotVerified:
+			utils.RespondError(w, http.StatusForbidden, "Email address has not been verified by the identity provider")
+		case errOIDCNoAccount:
+			utils.RespondError(w, http.StatusForbidden, "No account is linked to this identity")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		}
+		return
+	}
+
+	if user.IsSuspended() {
+		utils.RespondError(w, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if user.PasswordResetRequired {
+		utils.RespondError(w, http.StatusForbidden, "Password reset required, use the link sent to your email")
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		h.auth.respondMFAChallenge(w, user)
+		return
+	}
+
+	response, err := h.auth.issueTokens(r, user, "")
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.auth.audit.recordUser(r, models.AuditActionLogin, user.ID, map[string]string{"method": "oidc", "provider": providerName})
+	utils.RespondSuccess(w, "Login successful", response)
+}
+
+// resolveUser finds the user linked to the external identity. Unknown
+// identities are linked to the account with the same verified email, or a
+// new account is provisioned when auto-provisioning is enabled.
+func (h *OIDCHandler) resolveUser(providerName string, claims *oidc.IDTokenClaims) (*models.User, error) {
+	email := utils.NormalizeEmail(claims.Email)
+
+	identity, err := h.identityRepo.GetUserIdentity(providerName, claims.Subject)
+	if err == nil {
+		if email != "" && email != identity.Email {
+			if err := h.identityRepo.UpdateUserIdentityEmail(identity.ID, email); err != nil {
+				log.Printf("Failed to update email of identity %d: %v", identity.ID, err)
+			}
+		}
+		return h.auth.userRepo.GetUserByID(identity.UserID)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if email == "" || !claims.EmailVerified || !utils.ValidateEmail(email) {
+		return nil, errOIDCEmailNotVerified
+	}
+
+	identity = &models.UserIdentity{
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    email,
+	}
+
+	user, err := h.auth.userRepo.GetUserByEmail(email)
+	if err == nil {
+		identity.UserID = user.ID
+		if err := h.identityRepo.CreateUserIdentity(identity); err != nil {
+			return nil, err
+		}
+		// The provider vouched for the address, which is as good as our own
+		// verification link
+		if user.EmailVerifiedAt == nil {
+			if err := h.auth.userRepo.MarkEmailVerified(user.ID); err != nil {
+				return nil, err
+			}
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		return user, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if !config.AppConfig.OIDCAutoProvision {
+		return nil, errOIDCNoAccount
+	}
+
+	name := utils.NormalizeName(claims.Name)
+	if name == "" {
+		name = email[:strings.Index(email, "@")]
+	}
+	now := time.Now()
+	user = &models.User{
+		Name:            name,
+		Email:           email,
+		EmailVerifiedAt: &now,
+	}
+	if err := h.identityRepo.CreateUserWithIdentity(user, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func setOIDCStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.AppConfig.AppBaseURL, "https://"),
+		// Lax so that the cookie is sent on the top-level redirect back from
+		// the identity provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
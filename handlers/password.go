@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/mailer"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Email = utils.NormalizeEmail(req.Email)
+	if !utils.ValidateEmail(req.Email) {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid email format")
+		return
+	}
+
+	// The response is identical whether or not the account exists so that
+	// this endpoint cannot be used to discover registered emails. Unknown
+	// emails are throttled too, and the email is sent in the background, so
+	// neither a failed send nor the time it takes shows in the response.
+	const message = "If the email is registered, a password reset link has been sent"
+
+	wait, err := h.loginThrottle.throttleRequest(models.LoginAttemptKeyPasswordReset+req.Email, config.AppConfig.PasswordResetRequestInterval)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		utils.RespondError(w, http.StatusTooManyRequests, "Please wait before requesting another password reset link")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByEmail(req.Email)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondSuccess(w, message, nil)
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	sendInBackground("password reset", user.ID, func() error {
+		return h.sendPasswordReset(h.userTokenRepo, user)
+	})
+
+	utils.RespondSuccess(w, message, nil)
+}
+
+// sendInBackground runs send without holding up the response and logs a
+// failure. Endpoints that must not reveal whether an account exists use it so
+// that registered and unknown emails are answered alike.
+func sendInBackground(what string, userID uint, send func() error) {
+	go func() {
+		if err := send(); err != nil {
+			log.Printf("Failed to send %s to user %d: %v", what, userID, err)
+		}
+	}()
+}
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Token = strings.TrimSpace(req.Token)
+	req.Password = strings.TrimSpace(req.Password)
+
+	if req.Token == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Reset token is required")
+		return
+	}
+
+	// Look the token up without using it so that a rejected password does
+	// not cost the user their reset link
+	tokenHash := utils.HashToken(req.Token)
+	token, err := h.userTokenRepo.GetValidUserToken(tokenHash, models.UserTokenPurposePasswordReset)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(token.UserID)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	if violations := utils.CheckPassword(req.Password, user.Name, user.Email); len(violations) > 0 {
+		utils.RespondValidationErrors(w, violations)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to process password")
+		return
+	}
+
+	// The token is only used up together with the new password
+	err = h.audit.recordUserWith(r, models.AuditActionPasswordReset, token.UserID, nil, func(tx *gorm.DB) error {
+		if _, err := h.userTokenRepo.WithTx(tx).ConsumeUserToken(tokenHash, models.UserTokenPurposePasswordReset); err != nil {
+			return err
+		}
+		return h.userRepo.WithTx(tx).UpdatePassword(token.UserID, hashedPassword)
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	if err := h.userTokenRepo.InvalidateUserTokens(token.UserID, models.UserTokenPurposePasswordReset); err != nil {
+		log.Printf("Failed to invalidate reset tokens of user %d: %v", token.UserID, err)
+	}
+
+	utils.RespondSuccess(w, "Password reset successfully", nil)
+}
+
+// sendPasswordReset replaces any outstanding reset token of the user with a
+// new one, stored through tokens, and emails the reset link
+func (h *AuthHandler) sendPasswordReset(tokens models.UserTokenRepository, user *models.User) error {
+	if err := tokens.InvalidateUserTokens(user.ID, models.UserTokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	token, err := h.createUserToken(tokens, user.ID, models.UserTokenPurposePasswordReset, config.AppConfig.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := config.AppConfig.AppBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return h.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. Use the link below to choose a new one:\n\n%s\n\nThe link expires in %s. If you did not request a reset, you can ignore this email.\n",
+			user.Name, link, config.AppConfig.PasswordResetTTL),
+	})
+}
+
+// createUserToken stores the hash of a new single-use token through tokens
+// and returns the plain token to be sent to the user
+func (h *AuthHandler) createUserToken(tokens models.UserTokenRepository, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := tokens.CreateUserToken(&models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
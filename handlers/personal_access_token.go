@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type PersonalAccessTokenHandler struct {
+	patRepo models.PersonalAccessTokenRepository
+	audit   *auditLog
+}
+
+func NewPersonalAccessTokenHandler(patRepo models.PersonalAccessTokenRepository, auditRepo models.AuditEventRepository) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{
+		patRepo: patRepo,
+		audit:   newAuditLog(auditRepo),
+	}
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type PersonalAccessTokenResponse struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	TokenPrefix string     `json:"token_prefix"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type CreatedPersonalAccessTokenResponse struct {
+	PersonalAccessTokenResponse
+	// Token is only ever returned once, when the token is created
+	Token string `json:"token"`
+}
+
+func (h *PersonalAccessTokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req CreatePersonalAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+	if len(req.Name) > 100 {
+		utils.RespondError(w, http.StatusBadRequest, "Name must not exceed 100 characters")
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	scopes := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !models.ValidScopes[scope] {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid scope: "+scope)
+			return
+		}
+		scopes[scope] = true
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.RespondError(w, http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	secret, err := utils.GenerateSecureToken()
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+	tokenString := models.PersonalAccessTokenPrefix + secret
+
+	scopeList := make([]string, 0, len(scopes))
+	for scope := range scopes {
+		scopeList = append(scopeList, scope)
+	}
+	sort.Strings(scopeList)
+
+	token := &models.PersonalAccessToken{
+		UserID:      userClaims.UserID,
+		Name:        req.Name,
+		TokenHash:   utils.HashToken(tokenString),
+		TokenPrefix: tokenString[:len(models.PersonalAccessTokenPrefix)+4],
+		Scopes:      strings.Join(scopeList, " "),
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	err = h.audit.recordWith(r, models.AuditActionTokenCreate, &userClaims.UserID, models.AuditTargetToken, &token.ID,
+		map[string]interface{}{"name": token.Name, "scopes": scopeList}, func(tx *gorm.DB) error {
+			return h.patRepo.WithTx(tx).CreatePersonalAccessToken(token)
+		})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	utils.RespondCreated(w, "Token created successfully, copy it now as it will not be shown again", CreatedPersonalAccessTokenResponse{
+		PersonalAccessTokenResponse: newPersonalAccessTokenResponse(token),
+		Token:                       tokenString,
+	})
+}
+
+func (h *PersonalAccessTokenHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokens, err := h.patRepo.GetPersonalAccessTokensByUserID(userClaims.UserID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch tokens")
+		return
+	}
+
+	response := make([]PersonalAccessTokenResponse, len(tokens))
+	for i := range tokens {
+		response[i] = newPersonalAccessTokenResponse(&tokens[i])
+	}
+
+	utils.RespondSuccess(w, "Tokens fetched successfully", response)
+}
+
+func (h *PersonalAccessTokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	revokedID := uint(tokenID)
+	err = h.audit.recordWith(r, models.AuditActionTokenRevoke, &userClaims.UserID, models.AuditTargetToken, &revokedID, nil, func(tx *gorm.DB) error {
+		revoked, err := h.patRepo.WithTx(tx).RevokePersonalAccessToken(revokedID, userClaims.UserID)
+		if err == nil && !revoked {
+			return gorm.ErrRecordNotFound
+		}
+		return err
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "Token not found")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+
+	utils.RespondSuccess(w, "Token revoked successfully", nil)
+}
+
+func newPersonalAccessTokenResponse(token *models.PersonalAccessToken) PersonalAccessTokenResponse {
+	return PersonalAccessTokenResponse{
+		ID:          token.ID,
+		Name:        token.Name,
+		TokenPrefix: token.TokenPrefix,
+		Scopes:      token.ScopeList(),
+		ExpiresAt:   token.ExpiresAt,
+		LastUsedAt:  token.LastUsedAt,
+		CreatedAt:   token.CreatedAt,
+	}
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/mailer"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+// UpdateProfileRequest changes the fields that are set. An email change must
+// be confirmed with the current password, or a TOTP code for accounts
+// without one.
+type UpdateProfileRequest struct {
+	Name            *string `json:"name"`
+	Email           *string `json:"email"`
+	CurrentPassword string  `json:"current_password"`
+	Code            string  `json:"code"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	utils.RespondSuccess(w, "Profile fetched successfully", newUserResponse(user))
+}
+
+// UpdateProfile changes the name right away. A new email only takes effect
+// once confirmed through the link sent to it.
+func (h *AuthHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var violations []string
+	var name, email string
+	if req.Name != nil {
+		name = utils.NormalizeName(*req.Name)
+		if valid, msg := utils.ValidateName(name); !valid {
+			violations = append(violations, msg)
+		}
+	}
+	if req.Email != nil {
+		email = utils.NormalizeEmail(*req.Email)
+		if !utils.ValidateEmail(email) {
+			violations = append(violations, "Invalid email format")
+		}
+	}
+	if len(violations) > 0 {
+		utils.RespondValidationErrors(w, violations)
+		return
+	}
+
+	// Everything is checked before anything is written, so that a rejected
+	// request leaves the profile as it was
+	var newName, pendingEmail *string
+	if req.Name != nil && name != user.Name {
+		newName = &name
+	}
+	if req.Email != nil && email != user.Email {
+		if !h.reauthenticate(w, r, user, req.CurrentPassword, req.Code) {
+			return
+		}
+
+		taken, err := h.userRepo.IsEmailTaken(email)
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+		if taken {
+			utils.RespondError(w, http.StatusConflict, "Email already registered")
+			return
+		}
+		pendingEmail = &email
+	}
+
+	if newName != nil {
+		user.Name = name
+	}
+
+	message := "Profile updated successfully"
+	if pendingEmail == nil {
+		if err := h.userRepo.UpdateProfile(user.ID, newName, nil); err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+	} else {
+		// The confirmation is sent before the change is committed, so that a
+		// failed email leaves the profile as it was
+		user.PendingEmail = pendingEmail
+		err := h.audit.recordUserWith(r, models.AuditActionEmailChangeRequest, user.ID, map[string]string{"pending_email": email}, func(tx *gorm.DB) error {
+			if err := h.userRepo.WithTx(tx).UpdateProfile(user.ID, newName, pendingEmail); err != nil {
+				return err
+			}
+			if err := h.sendEmailChangeConfirmation(h.userTokenRepo.WithTx(tx), user); err != nil {
+				log.Printf("Failed to send email change confirmation to user %d: %v", user.ID, err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+		message = "Profile updated, confirm the new email address using the link sent to it"
+	}
+
+	utils.RespondSuccess(w, message, newUserResponse(user))
+}
+
+// ConfirmEmailChange applies a pending email change from the emailed link.
+// The user is signed out everywhere and logs in again with the new address.
+func (h *AuthHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	tokenString := strings.TrimSpace(r.URL.Query().Get("token"))
+	if tokenString == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Confirmation token is required")
+		return
+	}
+
+	tokenHash := utils.HashToken(tokenString)
+	token, err := h.userTokenRepo.GetValidUserToken(tokenHash, models.UserTokenPurposeEmailChange)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid or expired confirmation token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(token.UserID)
+	if err != nil || user.PendingEmail == nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid or expired confirmation token")
+		return
+	}
+
+	// The address may have been registered since the change was requested
+	newEmail := *user.PendingEmail
+	taken, err := h.userRepo.IsEmailTaken(newEmail)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+	if taken {
+		utils.RespondError(w, http.StatusConflict, "Email already registered")
+		return
+	}
+
+	// The token is only used up together with the change
+	err = h.audit.recordUserWith(r, models.AuditActionEmailChange, user.ID,
+		map[string]string{"email": newEmail, "previous_email": user.Email}, func(tx *gorm.DB) error {
+			if _, err := h.userTokenRepo.WithTx(tx).ConsumeUserToken(tokenHash, models.UserTokenPurposeEmailChange); err != nil {
+				return err
+			}
+			changed, err := h.userRepo.WithTx(tx).ConfirmEmailChange(user.ID, newEmail)
+			if err == nil && !changed {
+				return gorm.ErrRecordNotFound
+			}
+			return err
+		})
+	if err != nil {
+		switch err {
+		case models.ErrEmailTaken:
+			utils.RespondError(w, http.StatusConflict, "Email already registered")
+		case gorm.ErrRecordNotFound:
+			utils.RespondError(w, http.StatusBadRequest, "Invalid or expired confirmation token")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to change email")
+		}
+		return
+	}
+
+	// Let the previous address know, in case the change was not theirs
+	if err := h.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s. If you did not make this change, reset your password and contact support.\n",
+			user.Name, newEmail),
+	}); err != nil {
+		log.Printf("Failed to notify user %d of email change: %v", user.ID, err)
+	}
+
+	utils.RespondSuccess(w, "Email changed successfully, sign in again with the new address", nil)
+}
+
+// ChangePassword replaces the password after checking the current one. All
+// other sessions are signed out; the caller receives fresh tokens.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.CurrentPassword = strings.TrimSpace(req.CurrentPassword)
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+
+	if !h.checkThrottled(w, r, user, false, "Current password is incorrect", func() (bool, error) {
+		return utils.ComparePassword(user.Password, req.CurrentPassword) == nil, nil
+	}) {
+		return
+	}
+
+	violations := utils.CheckPassword(req.NewPassword, user.Name, user.Email)
+	if req.NewPassword == req.CurrentPassword {
+		violations = append(violations, "New password must be different from the current password")
+	}
+	if len(violations) > 0 {
+		utils.RespondValidationErrors(w, violations)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to process password")
+		return
+	}
+
+	err = h.audit.recordUserWith(r, models.AuditActionPasswordChange, user.ID, nil, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).UpdatePassword(user.ID, hashedPassword)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	// Reload to pick up the bumped token version
+	user, err = h.userRepo.GetUserByID(user.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	response, err := h.issueTokens(r, user, "")
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondTokens(w, "Password changed successfully", response, middleware.IsCookieAuth(r.Context()))
+}
+
+// DeleteAccount soft-deletes the user and their tasks once the user has
+// confirmed it is them
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// Recently signed in accounts without a password may send no body
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !h.reauthenticate(w, r, user, req.Password, req.Code) {
+		return
+	}
+
+	err := h.audit.recordUserWith(r, models.AuditActionAccountDelete, user.ID, nil, func(tx *gorm.DB) error {
+		return h.userRepo.WithTx(tx).SoftDeleteUser(user.ID)
+	})
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	clearSessionCookies(w)
+	utils.RespondSuccess(w, "Account deleted successfully", nil)
+}
+
+// reauthenticate confirms that a sensitive change is made by the user: with
+// their password or, for accounts without one, with a TOTP code when enrolled
+// or else a session started within the reauthentication window. It responds
+// with an error and returns false when the user could not be confirmed.
+func (h *AuthHandler) reauthenticate(w http.ResponseWriter, r *http.Request, user *models.User, password, code string) bool {
+	if user.Password != "" {
+		return h.checkThrottled(w, r, user, false, "Password is incorrect", func() (bool, error) {
+			return utils.ComparePassword(user.Password, strings.TrimSpace(password)) == nil, nil
+		})
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return h.checkThrottled(w, r, user, true, "Invalid code", func() (bool, error) {
+			return h.verifySecondFactor(user, code, "")
+		})
+	}
+
+	// Without a password or TOTP, only a fresh login proves it is the user
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok || userClaims.SessionID == 0 {
+		utils.RespondError(w, http.StatusForbidden, "Sign in again to confirm this change")
+		return false
+	}
+	session, err := h.sessionRepo.GetSessionByID(userClaims.SessionID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusForbidden, "Sign in again to confirm this change")
+			return false
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return false
+	}
+	if time.Since(session.CreatedAt) > config.AppConfig.ReauthWindow {
+		utils.RespondError(w, http.StatusForbidden, "Sign in again to confirm this change")
+		return false
+	}
+	return true
+}
+
+// checkThrottled runs check, which tests a password or code the signed-in
+// user sent, under the login throttle of their email, so that a stolen access
+// token is not enough to guess one. A failed check counts as a failed login
+// and is answered with 401 and the failure message. Like a login, a passed
+// check only forgets earlier failures once the last factor of the user has
+// passed, so that a known password does not reset the lockout for guessing
+// codes. It responds with an error and returns false unless check passes.
+func (h *AuthHandler) checkThrottled(w http.ResponseWriter, r *http.Request, user *models.User, secondFactor bool, failure string, check func() (bool, error)) bool {
+	ip := clientIP(r)
+	wait, err := h.loginThrottle.retryAfter(user.Email, ip)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return false
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		utils.RespondError(w, http.StatusTooManyRequests, "Too many failed attempts, please try again later")
+		return false
+	}
+
+	valid, err := check()
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return false
+	}
+	if !valid {
+		wait, err := h.loginThrottle.fail(user.Email, ip)
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+			return false
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		utils.RespondError(w, http.StatusUnauthorized, failure)
+		return false
+	}
+
+	if secondFactor || user.TOTPEnabledAt == nil {
+		if err := h.loginThrottle.succeed(user.Email); err != nil {
+			log.Printf("Failed to clear login attempts of user %d: %v", user.ID, err)
+		}
+	}
+	return true
+}
+
+// sendEmailChangeConfirmation emails a confirmation link to the pending
+// address of the user, storing its token through tokens
+func (h *AuthHandler) sendEmailChangeConfirmation(tokens models.UserTokenRepository, user *models.User) error {
+	if err := tokens.InvalidateUserTokens(user.ID, models.UserTokenPurposeEmailChange); err != nil {
+		return err
+	}
+
+	token, err := h.createUserToken(tokens, user.ID, models.UserTokenPurposeEmailChange, config.AppConfig.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := config.AppConfig.AppBaseURL + "/api/confirm-email-change?token=" + url.QueryEscape(token)
+	return h.mailer.Send(mailer.Message{
+		To:      *user.PendingEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that you want to use this address for your account by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.Name, link, config.AppConfig.EmailVerificationTTL),
+	})
+}
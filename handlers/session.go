@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+// maxUserAgentLength caps the stored User-Agent header
+const maxUserAgentLength = 512
+
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// ListSessions returns the active sessions of the user, most recently used
+// first
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// A session unused for longer than a refresh token lives cannot be
+	// resumed, so it is no longer listed
+	since := time.Now().Add(-config.AppConfig.RefreshTokenTTL)
+	sessions, err := h.sessionRepo.GetActiveSessionsByUserID(userClaims.UserID, since)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{
+			Session: session,
+			Current: session.ID == userClaims.SessionID,
+		}
+	}
+
+	utils.RespondSuccess(w, "Sessions fetched successfully", response)
+}
+
+// RevokeSession signs a session out. Its access token stops working right
+// away and its refresh token can no longer be used.
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	sessionID := uint(id)
+	err = h.audit.recordWith(r, models.AuditActionSessionRevoke, &userClaims.UserID, models.AuditTargetSession, &sessionID, nil, func(tx *gorm.DB) error {
+		_, err := h.sessionRepo.WithTx(tx).RevokeSession(sessionID, userClaims.UserID)
+		return err
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	utils.RespondSuccess(w, "Session revoked successfully", nil)
+}
+
+// startOrRotateSession records the access token jti on the session of the
+// refresh token family, starting a new session (and family) when familyID is
+// empty
+func (h *AuthHandler) startOrRotateSession(r *http.Request, userID uint, familyID, jti string) (*models.Session, error) {
+	ip := clientIP(r)
+
+	if familyID != "" {
+		session, err := h.sessionRepo.GetSessionByFamilyID(familyID)
+		if err == nil {
+			if err := h.sessionRepo.RotateSessionToken(session.ID, jti, ip); err != nil {
+				return nil, err
+			}
+			session.JTI = jti
+			return session, nil
+		}
+		// Families started before sessions were tracked get one on refresh
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	} else {
+		var err error
+		familyID, err = utils.GenerateSecureToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	userAgent := r.UserAgent()
+	if runes := []rune(userAgent); len(runes) > maxUserAgentLength {
+		userAgent = string(runes[:maxUserAgentLength])
+	}
+
+	session := &models.Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		JTI:        jti,
+		UserAgent:  userAgent,
+		IPAddress:  ip,
+		LastSeenAt: time.Now(),
+	}
+	if err := h.sessionRepo.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/config"
 	"github.com/hrusfandi/sb-task-management/middleware"
 	"github.com/hrusfandi/sb-task-management/models"
 	"github.com/hrusfandi/sb-task-management/utils"
@@ -14,25 +17,42 @@ import (
 )
 
 type TaskHandler struct {
-	taskRepo models.TaskRepository
+	taskRepo  models.TaskRepository
+	labelRepo models.LabelRepository
+	audit     *auditLog
 }
 
-func NewTaskHandler(taskRepo models.TaskRepository) *TaskHandler {
+func NewTaskHandler(taskRepo models.TaskRepository, labelRepo models.LabelRepository, auditRepo models.AuditEventRepository) *TaskHandler {
 	return &TaskHandler{
-		taskRepo: taskRepo,
+		taskRepo:  taskRepo,
+		labelRepo: labelRepo,
+		audit:     newAuditLog(auditRepo),
 	}
 }
 
 type CreateTaskRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Status      string `json:"status"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	Priority    string  `json:"priority"`
+	DueAt       *string `json:"due_at"`
+	AllDay      *bool   `json:"all_day"`
+	LabelIDs    []uint  `json:"label_ids"`
+	ParentID    *uint   `json:"parent_id"`
 }
 
+// UpdateTaskRequest changes the fields that are set. An empty due_at removes
+// the due date, label_ids replaces all labels of the task and a parent_id of
+// 0 makes it a top-level task.
 type UpdateTaskRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Status      string `json:"status"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Status      string  `json:"status"`
+	Priority    string  `json:"priority"`
+	DueAt       *string `json:"due_at"`
+	AllDay      *bool   `json:"all_day"`
+	LabelIDs    *[]uint `json:"label_ids"`
+	ParentID    *uint   `json:"parent_id"`
 }
 
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
@@ -65,18 +85,43 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	priority := models.TaskPriorityNone
+	if req.Priority != "" {
+		if priority, ok = models.ParseTaskPriority(req.Priority); !ok {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid priority value")
+			return
+		}
+	}
+
 	task := &models.Task{
 		Title:       req.Title,
 		Description: req.Description,
 		Status:      req.Status,
+		Priority:    priority,
 		UserID:      userClaims.UserID,
 	}
 
+	if msg := applyDueDate(task, req.DueAt, req.AllDay); msg != "" {
+		utils.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if task.Labels, ok = h.findLabels(w, userClaims.UserID, req.LabelIDs); !ok {
+		return
+	}
+
+	if req.ParentID != nil && !h.setParent(w, task, *req.ParentID) {
+		return
+	}
+
 	if err := h.taskRepo.CreateTask(task); err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to create task")
 		return
 	}
 
+	h.audit.record(r, models.AuditActionTaskCreate, &userClaims.UserID, models.AuditTargetTask, &task.ID,
+		map[string]taskSnapshot{"after": newTaskSnapshot(task)})
+
 	utils.RespondCreated(w, "Task created successfully", task)
 }
 
@@ -87,41 +132,8 @@ func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse query parameters
-	status := r.URL.Query().Get("status")
-	page := 1
-	limit := 10
-	sortBy := r.URL.Query().Get("sort_by")
-	order := r.URL.Query().Get("order")
-
-	// Parse page
-	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
-	}
-
-	// Parse limit
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
-	}
-
-	filter := models.TaskFilter{
-		Status: status,
-		Page:   page,
-		Limit:  limit,
-		SortBy: sortBy,
-		Order:  order,
-	}
-
-	// Validate status if provided
-	if status != "" &&
-	   status != models.TaskStatusPending &&
-	   status != models.TaskStatusInProgress &&
-	   status != models.TaskStatusCompleted {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid status value")
+	filter, ok := parseTaskFilter(w, r)
+	if !ok {
 		return
 	}
 
@@ -166,6 +178,38 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, "Task fetched successfully", task)
 }
 
+// ListSubtasks returns the direct subtasks of a task
+func (h *TaskHandler) ListSubtasks(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.findTask(w, r)
+	if !ok {
+		return
+	}
+
+	subtasks, err := h.taskRepo.GetSubtasks(task.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch subtasks")
+		return
+	}
+
+	utils.RespondSuccess(w, "Subtasks fetched successfully", subtasks)
+}
+
+// GetTaskTree returns a task with all its subtasks, nested
+func (h *TaskHandler) GetTaskTree(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.findTask(w, r)
+	if !ok {
+		return
+	}
+
+	tree, err := h.taskRepo.GetTaskTree(task.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch task tree")
+		return
+	}
+
+	utils.RespondSuccess(w, "Task tree fetched successfully", tree)
+}
+
 func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	userClaims, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
@@ -201,6 +245,8 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := newTaskSnapshot(task)
+
 	if req.Title != "" {
 		task.Title = strings.TrimSpace(req.Title)
 		if task.Title == "" {
@@ -220,14 +266,69 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusBadRequest, "Invalid status value")
 			return
 		}
+		// Blocked tasks can only be put back to pending
+		if config.AppConfig.EnforceTaskDependencies && task.IsBlocked &&
+			req.Status != task.Status && req.Status != models.TaskStatusPending {
+			utils.RespondError(w, http.StatusConflict, "Task is blocked by tasks that are not completed")
+			return
+		}
 		task.Status = req.Status
 	}
 
-	if err := h.taskRepo.UpdateTask(task); err != nil {
+	if req.Priority != "" {
+		priority, ok := models.ParseTaskPriority(req.Priority)
+		if !ok {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid priority value")
+			return
+		}
+		task.Priority = priority
+	}
+
+	if msg := applyDueDate(task, req.DueAt, req.AllDay); msg != "" {
+		utils.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if req.LabelIDs != nil {
+		if task.Labels, ok = h.findLabels(w, userClaims.UserID, *req.LabelIDs); !ok {
+			return
+		}
+	}
+
+	if req.ParentID != nil && !h.setParent(w, task, *req.ParentID) {
+		return
+	}
+
+	// Completing a task completes its subtasks, which must not bypass their
+	// own dependencies either
+	if config.AppConfig.EnforceTaskDependencies && task.Status == models.TaskStatusCompleted {
+		tree, err := h.taskRepo.GetTaskTree(task.ID)
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch subtasks")
+			return
+		}
+		if len(tree.BlockedOpenSubtaskIDs()) > 0 {
+			utils.RespondError(w, http.StatusConflict, "Subtasks are blocked by tasks that are not completed")
+			return
+		}
+	}
+
+	completedSubtasks, err := h.taskRepo.UpdateTask(task)
+	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to update task")
 		return
 	}
 
+	h.audit.record(r, models.AuditActionTaskUpdate, &userClaims.UserID, models.AuditTargetTask, &task.ID,
+		taskDiff(before, newTaskSnapshot(task)))
+	for i := range completedSubtasks {
+		subtask := &completedSubtasks[i]
+		subtaskBefore := newTaskSnapshot(subtask)
+		subtask.Status = models.TaskStatusCompleted
+		h.audit.record(r, models.AuditActionTaskUpdate, &userClaims.UserID, models.AuditTargetTask, &subtask.ID,
+			taskDiff(subtaskBefore, newTaskSnapshot(subtask)))
+	}
+
 	utils.RespondSuccess(w, "Task updated successfully", task)
 }
 
@@ -260,10 +361,315 @@ func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.taskRepo.DeleteTask(uint(taskID)); err != nil {
+	deletedSubtasks, err := h.taskRepo.DeleteTask(uint(taskID))
+	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to delete task")
 		return
 	}
 
+	h.audit.record(r, models.AuditActionTaskDelete, &userClaims.UserID, models.AuditTargetTask, &task.ID,
+		map[string]taskSnapshot{"before": newTaskSnapshot(task)})
+	for i := range deletedSubtasks {
+		subtask := &deletedSubtasks[i]
+		h.audit.record(r, models.AuditActionTaskDelete, &userClaims.UserID, models.AuditTargetTask, &subtask.ID,
+			map[string]taskSnapshot{"before": newTaskSnapshot(subtask)})
+	}
+
 	utils.RespondSuccess(w, "Task deleted successfully", nil)
-}
\ No newline at end of file
+}
+
+// parseTaskFilter reads the list query parameters, responding with an error
+// and returning false when they are invalid
+func parseTaskFilter(w http.ResponseWriter, r *http.Request) (models.TaskFilter, bool) {
+	// Parse query parameters
+	status := r.URL.Query().Get("status")
+	page := 1
+	limit := 10
+	sortBy := r.URL.Query().Get("sort_by")
+	order := r.URL.Query().Get("order")
+
+	// Parse page
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	// Parse limit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	filter := models.TaskFilter{
+		Status: status,
+		Page:   page,
+		Limit:  limit,
+	}
+
+	// sort_by takes one or more comma-separated fields, each optionally
+	// followed by :asc or :desc; order is the default direction. As they
+	// always have, an unknown plain field falls back to the default ordering
+	// and any order other than asc sorts descending.
+	order = strings.ToLower(strings.TrimSpace(order))
+	if sortBy != "" && !strings.ContainsAny(sortBy, ",:") && !models.TaskSortFields[strings.TrimSpace(sortBy)] {
+		sortBy = ""
+	}
+	if sortBy != "" {
+		for _, key := range strings.Split(sortBy, ",") {
+			field, direction, hasDirection := strings.Cut(strings.TrimSpace(key), ":")
+			direction = strings.ToLower(direction)
+			if !hasDirection {
+				direction = order
+			}
+			if !models.TaskSortFields[field] || (hasDirection && direction != "asc" && direction != "desc") {
+				utils.RespondError(w, http.StatusBadRequest, "Invalid sort_by value: "+key)
+				return filter, false
+			}
+			filter.Sort = append(filter.Sort, models.TaskSort{Field: field, Desc: direction != "asc"})
+		}
+	}
+
+	if priorities := r.URL.Query().Get("priority"); priorities != "" {
+		for _, name := range strings.Split(priorities, ",") {
+			priority, ok := models.ParseTaskPriority(strings.TrimSpace(name))
+			if !ok {
+				utils.RespondError(w, http.StatusBadRequest, "Invalid priority value")
+				return filter, false
+			}
+			filter.Priorities = append(filter.Priorities, priority)
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"due_before", &filter.DueBefore},
+		{"due_after", &filter.DueAfter},
+	} {
+		if value := r.URL.Query().Get(param.name); value != "" {
+			t, ok := parseTaskTime(value)
+			if !ok {
+				utils.RespondError(w, http.StatusBadRequest, "Invalid "+param.name+" value, use an RFC 3339 time or a date")
+				return filter, false
+			}
+			*param.dest = &t
+		}
+	}
+
+	// labels takes comma-separated label names; labels_match=all only keeps
+	// tasks that have every one of them
+	if labels := r.URL.Query().Get("labels"); labels != "" {
+		seen := make(map[string]bool)
+		for _, name := range strings.Split(labels, ",") {
+			name = strings.ToLower(utils.NormalizeName(name))
+			if name != "" && !seen[name] {
+				seen[name] = true
+				filter.Labels = append(filter.Labels, name)
+			}
+		}
+	}
+	switch r.URL.Query().Get("labels_match") {
+	case "", "any":
+	case "all":
+		filter.AllLabels = true
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "Invalid labels_match value, use any or all")
+		return filter, false
+	}
+
+	if overdueStr := r.URL.Query().Get("overdue"); overdueStr != "" {
+		overdue, err := strconv.ParseBool(overdueStr)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid overdue value")
+			return filter, false
+		}
+		filter.Overdue = &overdue
+	}
+
+	// tz is the IANA time zone of the client, in which all-day tasks become
+	// overdue once their date has passed
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid tz value, use an IANA time zone (e.g. Asia/Jakarta)")
+			return filter, false
+		}
+		filter.Location = location
+	}
+
+	// Validate status if provided
+	if status != "" &&
+	   status != models.TaskStatusPending &&
+	   status != models.TaskStatusInProgress &&
+	   status != models.TaskStatusCompleted {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid status value")
+		return filter, false
+	}
+
+	return filter, true
+}
+
+// applyDueDate sets the due date of the task from the request fields, which
+// keep the current value when nil. An empty due_at removes the due date and a
+// plain date makes the task all-day. All-day due dates are stored as the date
+// alone, at midnight UTC, so that they name the same day in every time zone.
+// It returns an error message for invalid input.
+func applyDueDate(task *models.Task, dueAt *string, allDay *bool) string {
+	if allDay != nil {
+		task.AllDay = *allDay
+	}
+
+	if dueAt != nil {
+		value := strings.TrimSpace(*dueAt)
+		if value == "" {
+			task.DueAt = nil
+		} else if date, err := time.Parse(models.TaskDateLayout, value); err == nil {
+			if allDay != nil && !*allDay {
+				return "A due date without a time can only be used for all-day tasks"
+			}
+			task.DueAt = &date
+			task.AllDay = true
+		} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+			task.DueAt = &t
+		} else {
+			return "Invalid due_at value, use an RFC 3339 time (e.g. 2025-03-01T17:00:00+07:00) or a date (e.g. 2025-03-01)"
+		}
+	}
+
+	if task.DueAt == nil {
+		if allDay != nil && *allDay {
+			return "All-day tasks need a due date"
+		}
+		task.AllDay = false
+		return ""
+	}
+	if task.AllDay {
+		year, month, day := task.DueAt.Date()
+		date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		task.DueAt = &date
+	}
+	return ""
+}
+
+// parseTaskTime reads a time filter: an RFC 3339 time, or a date meaning
+// midnight UTC
+func parseTaskTime(value string) (time.Time, bool) {
+	if date, err := time.Parse(models.TaskDateLayout, value); err == nil {
+		return date, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, err == nil
+}
+
+// findLabels loads the labels with the given IDs, responding with an error and
+// returning false unless they all belong to the user
+func (h *TaskHandler) findLabels(w http.ResponseWriter, userID uint, ids []uint) ([]models.Label, bool) {
+	unique := make(map[uint]bool)
+	for _, id := range ids {
+		unique[id] = true
+	}
+
+	labels, err := h.labelRepo.GetLabelsByIDs(userID, ids)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch labels")
+		return nil, false
+	}
+	if len(labels) != len(unique) {
+		utils.RespondError(w, http.StatusBadRequest, "Unknown label ID")
+		return nil, false
+	}
+	return labels, true
+}
+
+// findTask loads the task named by the URL, responding with an error and
+// returning false unless it belongs to the user
+func (h *TaskHandler) findTask(w http.ResponseWriter, r *http.Request) (*models.Task, bool) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return nil, false
+	}
+
+	taskID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid task ID")
+		return nil, false
+	}
+
+	task, err := h.taskRepo.GetTaskByID(uint(taskID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusNotFound, "Task not found")
+			return nil, false
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch task")
+		return nil, false
+	}
+
+	if task.UserID != userClaims.UserID {
+		utils.RespondError(w, http.StatusForbidden, "Access denied")
+		return nil, false
+	}
+	return task, true
+}
+
+// setParent moves the task under another task of its owner, or to the top
+// level when parentID is 0. It responds with an error and returns false when
+// the move would create a cycle or nest the tree deeper than MaxTaskDepth.
+func (h *TaskHandler) setParent(w http.ResponseWriter, task *models.Task, parentID uint) bool {
+	if parentID == 0 {
+		task.ParentID = nil
+		return true
+	}
+	if task.ParentID != nil && *task.ParentID == parentID {
+		return true
+	}
+	if parentID == task.ID {
+		utils.RespondError(w, http.StatusBadRequest, "A task cannot be its own subtask")
+		return false
+	}
+
+	parent, err := h.taskRepo.GetTaskByID(parentID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch parent task")
+		return false
+	}
+	if err == gorm.ErrRecordNotFound || parent.UserID != task.UserID {
+		utils.RespondError(w, http.StatusBadRequest, "Parent task not found")
+		return false
+	}
+
+	ancestorIDs, err := h.taskRepo.GetTaskAncestorIDs(parentID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch parent task")
+		return false
+	}
+
+	// A new task has no subtasks of its own
+	height := 1
+	if task.ID != 0 {
+		tree, err := h.taskRepo.GetTaskTree(task.ID)
+		if err != nil {
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch subtasks")
+			return false
+		}
+		height = tree.Height()
+	}
+
+	switch err := models.ValidateTaskParent(task.ID, parentID, ancestorIDs, height); err {
+	case nil:
+	case models.ErrTaskParentCycle:
+		utils.RespondError(w, http.StatusBadRequest, "A task cannot be moved under one of its own subtasks")
+		return false
+	default:
+		utils.RespondError(w, http.StatusBadRequest,
+			fmt.Sprintf("Subtasks cannot be nested more than %d levels deep", models.MaxTaskDepth))
+		return false
+	}
+
+	task.ParentID = &parentID
+	return true
+}
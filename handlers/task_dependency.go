@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type TaskDependencyRequest struct {
+	TaskID uint `json:"task_id"`
+}
+
+// ListDependencies returns the tasks the task is blocked by and the tasks it
+// blocks
+func (h *TaskHandler) ListDependencies(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.findTask(w, r)
+	if !ok {
+		return
+	}
+
+	dependencies, err := h.taskRepo.GetTaskDependencies(task.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch dependencies")
+		return
+	}
+
+	utils.RespondSuccess(w, "Dependencies fetched successfully", dependencies)
+}
+
+// AddBlockedBy makes the task wait for the task in the request body
+func (h *TaskHandler) AddBlockedBy(w http.ResponseWriter, r *http.Request) {
+	h.addDependency(w, r, false)
+}
+
+// AddBlocks makes the task in the request body wait for the task
+func (h *TaskHandler) AddBlocks(w http.ResponseWriter, r *http.Request) {
+	h.addDependency(w, r, true)
+}
+
+// RemoveBlockedBy stops the task from waiting for another one
+func (h *TaskHandler) RemoveBlockedBy(w http.ResponseWriter, r *http.Request) {
+	h.removeDependency(w, r, false)
+}
+
+// RemoveBlocks stops another task from waiting for the task
+func (h *TaskHandler) RemoveBlocks(w http.ResponseWriter, r *http.Request) {
+	h.removeDependency(w, r, true)
+}
+
+// addDependency links the task of the URL with the task of the request body,
+// which blocks it or, with blocks set, is blocked by it
+func (h *TaskHandler) addDependency(w http.ResponseWriter, r *http.Request, blocks bool) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	task, ok := h.findTask(w, r)
+	if !ok {
+		return
+	}
+
+	var req TaskDependencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.TaskID == 0 {
+		utils.RespondError(w, http.StatusBadRequest, "task_id is required")
+		return
+	}
+	if req.TaskID == task.ID {
+		utils.RespondError(w, http.StatusBadRequest, "A task cannot depend on itself")
+		return
+	}
+
+	other, err := h.taskRepo.GetTaskByID(req.TaskID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch task")
+		return
+	}
+	if err == gorm.ErrRecordNotFound || other.UserID != task.UserID {
+		utils.RespondError(w, http.StatusBadRequest, "Linked task not found")
+		return
+	}
+
+	taskID, dependsOnID := task.ID, other.ID
+	if blocks {
+		taskID, dependsOnID = other.ID, task.ID
+	}
+
+	if err := h.taskRepo.AddTaskDependency(taskID, dependsOnID); err != nil {
+		switch {
+		case errors.Is(err, models.ErrTaskDependencyExists):
+			utils.RespondError(w, http.StatusConflict, "Dependency already exists")
+		case errors.Is(err, models.ErrTaskDependencyCycle):
+			utils.RespondError(w, http.StatusConflict, "Dependency would create a cycle")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to add dependency")
+		}
+		return
+	}
+
+	h.audit.record(r, models.AuditActionTaskDependencyAdd, &userClaims.UserID, models.AuditTargetTask, &taskID,
+		map[string]uint{"depends_on_id": dependsOnID})
+
+	dependencies, err := h.taskRepo.GetTaskDependencies(task.ID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch dependencies")
+		return
+	}
+
+	utils.RespondCreated(w, "Dependency added successfully", dependencies)
+}
+
+// removeDependency removes the link between the task and the task named by
+// the otherID URL parameter, which blocks it or, with blocks set, is blocked
+// by it
+func (h *TaskHandler) removeDependency(w http.ResponseWriter, r *http.Request, blocks bool) {
+	userClaims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	task, ok := h.findTask(w, r)
+	if !ok {
+		return
+	}
+
+	otherID, err := strconv.ParseUint(chi.URLParam(r, "otherID"), 10, 32)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid task ID")
+		return
+	}
+
+	taskID, dependsOnID := task.ID, uint(otherID)
+	if blocks {
+		taskID, dependsOnID = uint(otherID), task.ID
+	}
+
+	removed, err := h.taskRepo.RemoveTaskDependency(taskID, dependsOnID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to remove dependency")
+		return
+	}
+	if !removed {
+		utils.RespondError(w, http.StatusNotFound, "Dependency not found")
+		return
+	}
+
+	h.audit.record(r, models.AuditActionTaskDependencyRemove, &userClaims.UserID, models.AuditTargetTask, &taskID,
+		map[string]uint{"depends_on_id": dependsOnID})
+
+	utils.RespondSuccess(w, "Dependency removed successfully", nil)
+}
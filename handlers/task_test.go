@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/models"
+)
+
+func TestParseTaskFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []models.TaskSort
+	}{
+		{"No sort", "", nil},
+		{"Single field", "sort_by=title", []models.TaskSort{{Field: "title", Desc: true}}},
+		{"Single field with order", "sort_by=title&order=asc", []models.TaskSort{{Field: "title"}}},
+		{"Order is case-insensitive", "sort_by=title&order=ASC", []models.TaskSort{{Field: "title"}}},
+		{"Unknown order sorts descending", "sort_by=title&order=ascending", []models.TaskSort{{Field: "title", Desc: true}}},
+		{"Unknown single field falls back to the default", "sort_by=password&order=asc", nil},
+		{
+			"Several fields",
+			"sort_by=priority:DESC,due_at:asc",
+			[]models.TaskSort{{Field: "priority", Desc: true}, {Field: "due_at"}},
+		},
+		{
+			"Order applies to fields without a suffix",
+			"sort_by=priority,due_at:desc&order=asc",
+			[]models.TaskSort{{Field: "priority"}, {Field: "due_at", Desc: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			filter, ok := parseTaskFilter(w, httptest.NewRequest(http.MethodGet, "/tasks?"+tt.query, nil))
+			if !ok {
+				t.Fatalf("parseTaskFilter() rejected the query: %s", w.Body.String())
+			}
+			if !reflect.DeepEqual(filter.Sort, tt.want) {
+				t.Errorf("Sort = %v, want %v", filter.Sort, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTaskFilterFields(t *testing.T) {
+	w := httptest.NewRecorder()
+	query := "/tasks?page=2&limit=5&status=pending&priority=high,urgent&due_before=2025-03-01" +
+		"&labels=Backend,backend,%20ui&labels_match=all&overdue=true&tz=Asia/Jakarta"
+	filter, ok := parseTaskFilter(w, httptest.NewRequest(http.MethodGet, query, nil))
+	if !ok {
+		t.Fatalf("parseTaskFilter() rejected the query: %s", w.Body.String())
+	}
+
+	if filter.Page != 2 || filter.Limit != 5 || filter.Status != models.TaskStatusPending {
+		t.Errorf("Page, Limit, Status = %d, %d, %q", filter.Page, filter.Limit, filter.Status)
+	}
+	if want := []models.TaskPriority{models.TaskPriorityHigh, models.TaskPriorityUrgent}; !reflect.DeepEqual(filter.Priorities, want) {
+		t.Errorf("Priorities = %v, want %v", filter.Priorities, want)
+	}
+	if want := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC); filter.DueBefore == nil || !filter.DueBefore.Equal(want) {
+		t.Errorf("DueBefore = %v, want %v", filter.DueBefore, want)
+	}
+	if want := []string{"backend", "ui"}; !reflect.DeepEqual(filter.Labels, want) || !filter.AllLabels {
+		t.Errorf("Labels, AllLabels = %v, %v, want %v, true", filter.Labels, filter.AllLabels, want)
+	}
+	if filter.Overdue == nil || !*filter.Overdue {
+		t.Errorf("Overdue = %v, want true", filter.Overdue)
+	}
+	if filter.Location == nil || filter.Location.String() != "Asia/Jakarta" {
+		t.Errorf("Location = %v, want Asia/Jakarta", filter.Location)
+	}
+}
+
+func TestParseTaskFilterInvalid(t *testing.T) {
+	queries := []string{
+		"sort_by=title,password",
+		"sort_by=password:asc",
+		"sort_by=title:up",
+		"status=done",
+		"priority=high,critical",
+		"due_after=tomorrow",
+		"labels_match=some",
+		"overdue=maybe",
+		"tz=Mars/Olympus",
+		"tz=Local",
+	}
+
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if _, ok := parseTaskFilter(w, httptest.NewRequest(http.MethodGet, "/tasks?"+query, nil)); ok {
+				t.Fatal("parseTaskFilter() accepted the query")
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestApplyDueDate(t *testing.T) {
+	str := func(s string) *string { return &s }
+	boolean := func(b bool) *bool { return &b }
+	at := func(t time.Time) *time.Time { return &t }
+	due := time.Date(2025, 3, 1, 17, 0, 0, 0, time.FixedZone("", 7*60*60))
+
+	tests := []struct {
+		name       string
+		task       models.Task
+		dueAt      *string
+		allDay     *bool
+		wantDueAt  *time.Time
+		wantAllDay bool
+		wantErr    bool
+	}{
+		{"Nothing set", models.Task{}, nil, nil, nil, false, false},
+		{"Time", models.Task{}, str("2025-03-01T17:00:00+07:00"), nil, at(due), false, false},
+		{"Date makes the task all-day", models.Task{}, str("2025-03-01"), nil, at(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)), true, false},
+		{"All-day time is moved to midnight UTC", models.Task{}, str("2025-03-01T17:00:00+07:00"), boolean(true), at(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)), true, false},
+		{"Empty due date removes it", models.Task{DueAt: at(due), AllDay: true}, str(""), nil, nil, false, false},
+		{"Unchanged due date is kept", models.Task{DueAt: at(due)}, nil, nil, at(due), false, false},
+		{"Date on a timed task", models.Task{}, str("2025-03-01"), boolean(false), nil, false, true},
+		{"All-day without a due date", models.Task{}, nil, boolean(true), nil, false, true},
+		{"Invalid due date", models.Task{}, str("next friday"), nil, nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := tt.task
+			msg := applyDueDate(&task, tt.dueAt, tt.allDay)
+			if (msg != "") != tt.wantErr {
+				t.Fatalf("applyDueDate() = %q, wantErr %v", msg, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (task.DueAt == nil) != (tt.wantDueAt == nil) || (task.DueAt != nil && !task.DueAt.Equal(*tt.wantDueAt)) {
+				t.Errorf("DueAt = %v, want %v", task.DueAt, tt.wantDueAt)
+			}
+			if task.AllDay != tt.wantAllDay {
+				t.Errorf("AllDay = %v, want %v", task.AllDay, tt.wantAllDay)
+			}
+		})
+	}
+}
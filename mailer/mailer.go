@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/hrusfandi/sb-task-management/config"
+)
+
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New creates the mailer selected by the MAIL_DRIVER configuration
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case DriverSMTP:
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case DriverFile:
+		return NewFileMailer(cfg.MailOutboxDir, cfg.MailFrom)
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.MailDriver)
+	}
+}
@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+
+	m.Send(Message{To: "a@example.com", Subject: "First", Body: "one"})
+	m.Send(Message{To: "b@example.com", Subject: "Second", Body: "two"})
+	m.Send(Message{To: "a@example.com", Subject: "Third", Body: "three"})
+
+	if got := len(m.Messages()); got != 3 {
+		t.Errorf("Messages() returned %d messages, want 3", got)
+	}
+
+	msg, ok := m.Last("A@example.com")
+	if !ok {
+		t.Fatal("Last() found no message")
+	}
+	if msg.Subject != "Third" {
+		t.Errorf("Last() subject = %v, want Third", msg.Subject)
+	}
+
+	if _, ok := m.Last("nobody@example.com"); ok {
+		t.Error("Last() found a message for an unknown recipient")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := NewFileMailer(dir, "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(Message{To: "user@example.com", Subject: "Reset", Body: "line one\nline two"}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("outbox contains %d files, want 2", len(entries))
+	}
+
+	data, err := os.ReadFile(dir + "/" + entries[0].Name())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	content := string(data)
+	for _, want := range []string{"From: no-reply@example.com", "To: user@example.com", "Subject: Reset", "line one\r\nline two"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("email does not contain %q:\n%s", want, content)
+		}
+	}
+}
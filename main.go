@@ -4,18 +4,56 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/hrusfandi/sb-task-management/config"
 	"github.com/hrusfandi/sb-task-management/database"
+	"github.com/hrusfandi/sb-task-management/mailer"
+	"github.com/hrusfandi/sb-task-management/models"
 	"github.com/hrusfandi/sb-task-management/routes"
+	"github.com/hrusfandi/sb-task-management/utils"
 )
 
 func main() {
 	config.LoadConfig()
 
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	keyring, err := utils.InitKeyring()
+	if err != nil {
+		log.Fatal("Failed to initialize signing keys:", err)
+	}
+	if config.AppConfig.JWTKeyRotationInterval > 0 && keyring.Algorithm() != utils.AlgorithmHS256 {
+		go keyring.StartRotation(config.AppConfig.JWTKeyRotationInterval)
+	} else if keyring.Algorithm() != utils.AlgorithmHS256 {
+		// Pick up keys rotated by the instance that rotates them
+		go keyring.StartReloading(utils.KeyringReloadInterval)
+	}
+
+	if _, err := utils.InitPasswordPolicy(); err != nil {
+		log.Fatal("Failed to initialize password policy:", err)
+	}
+	if _, err := utils.InitPasswordHasher(); err != nil {
+		log.Fatal("Failed to initialize password hasher:", err)
+	}
+
+	mail, err := mailer.New(config.AppConfig)
+	if err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+
 	database.InitDB()
 
-	r := routes.SetupRoutes(database.GetDB())
+	r := routes.SetupRoutes(database.GetDB(), keyring, mail)
+
+	go pruneRevokedTokens(models.NewRevokedTokenRepository(database.GetDB()))
+	go pruneLoginAttempts(models.NewLoginAttemptRepository(database.GetDB()))
 
 	log.Println("Task Management API is starting...")
 	log.Printf("Server running on http://localhost:%s", config.AppConfig.Port)
@@ -23,4 +61,22 @@ func main() {
 	if err := http.ListenAndServe(fmt.Sprintf(":%s", config.AppConfig.Port), r); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
-}
\ No newline at end of file
+}
+
+// pruneRevokedTokens drops denylist entries whose tokens have expired anyway
+func pruneRevokedTokens(repo models.RevokedTokenRepository) {
+	for range time.Tick(time.Hour) {
+		if err := repo.DeleteExpiredRevokedTokens(); err != nil {
+			log.Println("Failed to prune revoked tokens:", err)
+		}
+	}
+}
+
+// pruneLoginAttempts drops failure counters that no longer affect logins
+func pruneLoginAttempts(repo models.LoginAttemptRepository) {
+	for range time.Tick(time.Hour) {
+		if err := repo.DeleteStaleLoginAttempts(config.AppConfig.LoginLockoutDuration); err != nil {
+			log.Println("Failed to prune login attempts:", err)
+		}
+	}
+}
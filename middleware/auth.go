@@ -2,21 +2,52 @@ package middleware
 
 import (
 	"context"
+	"log"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/hrusfandi/sb-task-management/models"
 	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
 )
 
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey                contextKey = "user"
+	CurrentUserContextKey         contextKey = "current_user"
+	PersonalAccessTokenContextKey contextKey = "personal_access_token"
+	CookieAuthContextKey          contextKey = "cookie_auth"
+	ImpersonatorContextKey        contextKey = "impersonator"
+)
+
+type Authenticator struct {
+	userRepo         models.UserRepository
+	revokedTokenRepo models.RevokedTokenRepository
+	patRepo          models.PersonalAccessTokenRepository
+	sessionRepo      models.SessionRepository
+}
+
+func NewAuthenticator(
+	userRepo models.UserRepository,
+	revokedTokenRepo models.RevokedTokenRepository,
+	patRepo models.PersonalAccessTokenRepository,
+	sessionRepo models.SessionRepository,
+) *Authenticator {
+	return &Authenticator{
+		userRepo:         userRepo,
+		revokedTokenRepo: revokedTokenRepo,
+		patRepo:          patRepo,
+		sessionRepo:      sessionRepo,
+	}
+}
 
-func JWTAuth(next http.Handler) http.Handler {
+func (a *Authenticator) JWTAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			utils.RespondError(w, http.StatusUnauthorized, "Authorization header required")
+			a.authenticateCookie(w, r, next)
 			return
 		}
 
@@ -27,18 +58,287 @@ func JWTAuth(next http.Handler) http.Handler {
 		}
 
 		tokenString := bearerToken[1]
-		claims, err := utils.ValidateToken(tokenString)
-		if err != nil {
-			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired token")
+		if strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix) {
+			a.authenticatePersonalAccessToken(w, r, next, tokenString)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserContextKey, claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		a.authenticateAccessToken(w, r, next, tokenString)
 	})
 }
 
+// authenticateCookie serves the request with the access token of a cookie
+// login. The browser sends the cookie on its own, so state-changing requests
+// must also prove they come from our frontend with the CSRF token.
+func (a *Authenticator) authenticateCookie(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	cookie, err := r.Cookie(AccessTokenCookie)
+	if err != nil || cookie.Value == "" {
+		utils.RespondError(w, http.StatusUnauthorized, "Authorization header required")
+		return
+	}
+
+	if !isSafeMethod(r.Method) && !ValidCSRFToken(r) {
+		utils.RespondError(w, http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), CookieAuthContextKey, true)
+	a.authenticateAccessToken(w, r.WithContext(ctx), next, cookie.Value)
+}
+
+// authenticateAccessToken serves the request on behalf of the user the
+// access token was issued to
+func (a *Authenticator) authenticateAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, tokenString string) {
+	claims, err := utils.ValidateToken(tokenString)
+	if err != nil || claims.ID == "" || claims.Purpose != "" {
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return
+	}
+
+	revoked, err := a.revokedTokenRepo.IsTokenRevoked(claims.ID)
+	if err != nil {
+		log.Printf("Failed to check token revocation: %v", err)
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if revoked {
+		utils.RespondError(w, http.StatusUnauthorized, "Token has been revoked")
+		return
+	}
+
+	user, err := a.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		utils.RespondError(w, http.StatusUnauthorized, "Token has been revoked")
+		return
+	}
+
+	if claims.SessionID != 0 && !a.checkSession(w, r, claims) {
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, claims)
+	ctx = context.WithValue(ctx, CurrentUserContextKey, user)
+
+	if claims.IsImpersonation() {
+		impersonator, ok := a.checkImpersonator(w, claims)
+		if !ok {
+			return
+		}
+		ctx = context.WithValue(ctx, ImpersonatorContextKey, impersonator)
+		log.Printf("[impersonation] admin %d (%s) acting as user %d (%s): %s %s",
+			impersonator.ID, impersonator.Email, user.ID, user.Email, r.Method, r.RequestURI)
+	}
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// checkImpersonator loads the admin behind an impersonation token. The token
+// dies with the admin's role or sessions: it is refused once they are no
+// longer an admin, are suspended or have been signed out everywhere.
+func (a *Authenticator) checkImpersonator(w http.ResponseWriter, claims *utils.JWTClaims) (*models.User, bool) {
+	admin, err := a.userRepo.GetUserByID(claims.ImpersonatorID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Token has been revoked")
+			return nil, false
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return nil, false
+	}
+	if admin.Role != models.RoleAdmin || admin.IsSuspended() || admin.TokenVersion != claims.ImpersonatorTokenVersion {
+		utils.RespondError(w, http.StatusUnauthorized, "Token has been revoked")
+		return nil, false
+	}
+	return admin, true
+}
+
+// checkSession rejects tokens whose session has been revoked and records
+// activity on the others. Tokens issued before sessions were tracked carry no
+// session ID and are not checked.
+func (a *Authenticator) checkSession(w http.ResponseWriter, r *http.Request, claims *utils.JWTClaims) bool {
+	session, err := a.sessionRepo.GetSessionByID(claims.SessionID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return false
+	}
+	if err != nil || session.UserID != claims.UserID || session.RevokedAt != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Session has been revoked")
+		return false
+	}
+
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if err := a.sessionRepo.TouchSession(session.ID, ip); err != nil {
+		log.Printf("Failed to record session activity: %v", err)
+	}
+	return true
+}
+
+// authenticatePersonalAccessToken serves the request on behalf of the owner
+// of a personal access token. The token's scopes are enforced by RequireScope.
+func (a *Authenticator) authenticatePersonalAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, tokenString string) {
+	pat, err := a.patRepo.GetPersonalAccessTokenByHash(utils.HashToken(tokenString))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	if !pat.IsActive() {
+		utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return
+	}
+
+	user, err := a.userRepo.GetUserByID(pat.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.RespondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to authenticate")
+		return
+	}
+	// Unlike access tokens, personal access tokens survive a suspension or a
+	// forced password reset and are refused while either is in effect
+	if user.IsSuspended() {
+		utils.RespondError(w, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if user.PasswordResetRequired {
+		utils.RespondError(w, http.StatusForbidden, "Password reset required")
+		return
+	}
+
+	if err := a.patRepo.TouchPersonalAccessToken(pat.ID); err != nil {
+		log.Printf("Failed to record personal access token use: %v", err)
+	}
+
+	claims := &utils.JWTClaims{
+		UserID:       user.ID,
+		Email:        user.Email,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, claims)
+	ctx = context.WithValue(ctx, CurrentUserContextKey, user)
+	ctx = context.WithValue(ctx, PersonalAccessTokenContextKey, pat)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// GetUserFromContext returns the claims of the authenticated user. On an
+// impersonation token, UserID is the impersonated user and ImpersonatorID the
+// admin acting as them.
 func GetUserFromContext(ctx context.Context) (*utils.JWTClaims, bool) {
 	claims, ok := ctx.Value(UserContextKey).(*utils.JWTClaims)
 	return claims, ok
-}
\ No newline at end of file
+}
+
+// GetCurrentUser returns the authenticated user as loaded by JWTAuth
+func GetCurrentUser(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(CurrentUserContextKey).(*models.User)
+	return user, ok
+}
+
+// GetImpersonator returns the admin acting as the authenticated user, if the
+// request was made with an impersonation token
+func GetImpersonator(ctx context.Context) (*models.User, bool) {
+	admin, ok := ctx.Value(ImpersonatorContextKey).(*models.User)
+	return admin, ok
+}
+
+// RequireVerifiedEmail rejects users who have not confirmed their email
+// address. It must run after JWTAuth.
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := GetCurrentUser(r.Context())
+		if !ok {
+			utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+			return
+		}
+		if user.EmailVerifiedAt == nil {
+			utils.RespondError(w, http.StatusForbidden, "Email address has not been verified")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetPersonalAccessToken returns the personal access token the request was
+// authenticated with, if any
+func GetPersonalAccessToken(ctx context.Context) (*models.PersonalAccessToken, bool) {
+	pat, ok := ctx.Value(PersonalAccessTokenContextKey).(*models.PersonalAccessToken)
+	return pat, ok
+}
+
+// RequireScope rejects requests made with a personal access token that was
+// not granted the scope. Interactive logins are not scoped and always pass.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pat, ok := GetPersonalAccessToken(r.Context()); ok && !pat.HasScope(scope) {
+				utils.RespondError(w, http.StatusForbidden, "Token is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyPersonalAccessTokens restricts account management endpoints to
+// interactive logins
+func DenyPersonalAccessTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetPersonalAccessToken(r.Context()); ok {
+			utils.RespondError(w, http.StatusForbidden, "Personal access tokens cannot be used for this endpoint")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DenyImpersonation keeps admins acting as another user away from endpoints
+// that change the user's credentials or sessions
+func DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := GetUserFromContext(r.Context()); ok && claims.IsImpersonation() {
+			utils.RespondError(w, http.StatusForbidden, "This action is not allowed while impersonating a user")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets users with one of the roles through. The role is
+// taken from the user loaded by JWTAuth rather than the token claims, so a
+// changed role applies immediately.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetCurrentUser(r.Context())
+			if !ok {
+				utils.RespondError(w, http.StatusUnauthorized, "User not authenticated")
+				return
+			}
+			for _, role := range roles {
+				if user.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+type fakeUserRepo struct {
+	models.UserRepository
+	users map[uint]*models.User
+}
+
+func (f *fakeUserRepo) GetUserByID(id uint) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+type fakeRevokedTokenRepo struct {
+	models.RevokedTokenRepository
+	revoked map[string]bool
+}
+
+func (f *fakeRevokedTokenRepo) RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	f.revoked[jti] = true
+	return nil
+}
+
+func (f *fakeRevokedTokenRepo) IsTokenRevoked(jti string) (bool, error) {
+	return f.revoked[jti], nil
+}
+
+type fakePersonalAccessTokenRepo struct {
+	models.PersonalAccessTokenRepository
+	tokens map[string]*models.PersonalAccessToken
+}
+
+func (f *fakePersonalAccessTokenRepo) GetPersonalAccessTokenByHash(tokenHash string) (*models.PersonalAccessToken, error) {
+	token, ok := f.tokens[tokenHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (f *fakePersonalAccessTokenRepo) TouchPersonalAccessToken(id uint) error {
+	return nil
+}
+
+// newTestAuthenticator returns an authenticator knowing the users, with the
+// denylist behind the same cache the server uses
+func newTestAuthenticator(users ...*models.User) (*Authenticator, *fakeUserRepo, models.RevokedTokenRepository) {
+	config.AppConfig = &config.Config{
+		JWTSecret:      "test-secret-key-for-testing",
+		AccessTokenTTL: 15 * time.Minute,
+	}
+	utils.SetKeyring(utils.NewHMACKeyring(config.AppConfig.JWTSecret))
+
+	userRepo := &fakeUserRepo{users: map[uint]*models.User{}}
+	for _, user := range users {
+		userRepo.users[user.ID] = user
+	}
+	revokedTokenRepo := models.NewCachedRevokedTokenRepository(&fakeRevokedTokenRepo{revoked: map[string]bool{}})
+	return NewAuthenticator(userRepo, revokedTokenRepo, nil, nil), userRepo, revokedTokenRepo
+}
+
+// serve runs the request through the middleware in front of a handler that
+// answers 200
+func serve(middleware func(http.Handler) http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, r)
+	return w
+}
+
+func bearerRequest(method, token string) *http.Request {
+	r := httptest.NewRequest(method, "/api/tasks", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTAuthAccessToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		revoke     func(jti string, users *fakeUserRepo, denylist models.RevokedTokenRepository)
+		wantStatus int
+	}{
+		{
+			name:       "Valid token",
+			revoke:     func(string, *fakeUserRepo, models.RevokedTokenRepository) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Denylisted jti",
+			revoke: func(jti string, _ *fakeUserRepo, denylist models.RevokedTokenRepository) {
+				denylist.RevokeToken(jti, 1, time.Now().Add(time.Hour))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Token version bumped",
+			revoke: func(_ string, users *fakeUserRepo, _ models.RevokedTokenRepository) {
+				users.users[1].TokenVersion++
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "User deleted",
+			revoke: func(_ string, users *fakeUserRepo, _ models.RevokedTokenRepository) {
+				delete(users.users, 1)
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, users, denylist := newTestAuthenticator(&models.User{ID: 1, Email: "test@example.com", TokenVersion: 3})
+			token, err := utils.IssueToken(utils.JWTClaims{UserID: 1, Email: "test@example.com", TokenVersion: 3}, time.Minute)
+			if err != nil {
+				t.Fatalf("IssueToken() error = %v", err)
+			}
+			claims, err := utils.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+
+			// The first request caches the jti as not revoked, which a
+			// revocation must override
+			if w := serve(authenticator.JWTAuth, bearerRequest(http.MethodGet, token)); w.Code != http.StatusOK {
+				t.Fatalf("first request status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+
+			tt.revoke(claims.ID, users, denylist)
+			if w := serve(authenticator.JWTAuth, bearerRequest(http.MethodGet, token)); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTAuthRejectsInvalidTokens(t *testing.T) {
+	authenticator, _, _ := newTestAuthenticator(&models.User{ID: 1, Email: "test@example.com"})
+
+	mfaToken, err := utils.IssueToken(utils.JWTClaims{UserID: 1, Purpose: utils.TokenPurposeMFA}, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	expiredToken, err := utils.IssueToken(utils.JWTClaims{UserID: 1}, -time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"Missing header", ""},
+		{"Not a bearer token", "Basic dXNlcjpwYXNz"},
+		{"Malformed token", "Bearer not-a-token"},
+		{"Expired token", "Bearer " + expiredToken},
+		{"MFA challenge token", "Bearer " + mfaToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if w := serve(authenticator.JWTAuth, r); w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestJWTAuthPersonalAccessToken(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	suspendedAt := past
+
+	tests := []struct {
+		name       string
+		token      models.PersonalAccessToken
+		user       models.User
+		wantStatus int
+	}{
+		{"Active token", models.PersonalAccessToken{ExpiresAt: &future}, models.User{}, http.StatusOK},
+		{"Token without expiry", models.PersonalAccessToken{}, models.User{}, http.StatusOK},
+		{"Expired token", models.PersonalAccessToken{ExpiresAt: &past}, models.User{}, http.StatusUnauthorized},
+		{"Revoked token", models.PersonalAccessToken{RevokedAt: &past}, models.User{}, http.StatusUnauthorized},
+		{"Suspended owner", models.PersonalAccessToken{}, models.User{SuspendedAt: &suspendedAt}, http.StatusForbidden},
+		{"Owner must reset password", models.PersonalAccessToken{}, models.User{PasswordResetRequired: true}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.user
+			user.ID = 1
+			authenticator, _, _ := newTestAuthenticator(&user)
+			pat := tt.token
+			pat.ID, pat.UserID, pat.Scopes = 1, 1, models.ScopeTasksRead
+			authenticator.patRepo = &fakePersonalAccessTokenRepo{tokens: map[string]*models.PersonalAccessToken{
+				utils.HashToken(models.PersonalAccessTokenPrefix + "secret"): &pat,
+			}}
+
+			w := serve(authenticator.JWTAuth, bearerRequest(http.MethodGet, models.PersonalAccessTokenPrefix+"secret"))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPersonalAccessTokenRestrictions(t *testing.T) {
+	readOnly := &models.PersonalAccessToken{ID: 1, UserID: 1, Scopes: models.ScopeTasksRead}
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		pat        *models.PersonalAccessToken
+		wantStatus int
+	}{
+		{"Granted scope", RequireScope(models.ScopeTasksRead), readOnly, http.StatusOK},
+		{"Missing scope", RequireScope(models.ScopeTasksWrite), readOnly, http.StatusForbidden},
+		{"Interactive login is not scoped", RequireScope(models.ScopeTasksWrite), nil, http.StatusOK},
+		{"Token on an account endpoint", DenyPersonalAccessTokens, readOnly, http.StatusForbidden},
+		{"Interactive login on an account endpoint", DenyPersonalAccessTokens, nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+			if tt.pat != nil {
+				r = r.WithContext(context.WithValue(r.Context(), PersonalAccessTokenContextKey, tt.pat))
+			}
+			if w := serve(tt.middleware, r); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	admin := &models.User{ID: 1, Email: "admin@example.com", Role: models.RoleAdmin, TokenVersion: 2}
+	user := &models.User{ID: 2, Email: "user@example.com", Role: models.RoleUser}
+
+	tests := []struct {
+		name       string
+		claims     utils.JWTClaims
+		wantStatus int
+	}{
+		{"Own token", utils.JWTClaims{UserID: 2, Email: user.Email}, http.StatusOK},
+		{"Impersonation token", utils.JWTClaims{UserID: 2, Email: user.Email, ImpersonatorID: 1, ImpersonatorTokenVersion: 2}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, _, _ := newTestAuthenticator(admin, user)
+			token, err := utils.IssueToken(tt.claims, time.Minute)
+			if err != nil {
+				t.Fatalf("IssueToken() error = %v", err)
+			}
+
+			authenticated := func(next http.Handler) http.Handler {
+				return authenticator.JWTAuth(DenyImpersonation(next))
+			}
+			if w := serve(authenticated, bearerRequest(http.MethodPost, token)); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	// AccessTokenCookie carries the access token of cookie logins
+	AccessTokenCookie = "access_token"
+	// CSRFCookie holds the token the frontend must echo in CSRFHeader. It is
+	// readable by scripts on purpose; other sites can neither read it nor set
+	// the header.
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// ValidCSRFToken reports whether the request carries the same non-empty CSRF
+// token in its cookie and header
+func ValidCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+// IsCookieAuth reports whether the request was authenticated with the access
+// token cookie rather than an Authorization header
+func IsCookieAuth(ctx context.Context) bool {
+	cookieAuth, _ := ctx.Value(CookieAuthContextKey).(bool)
+	return cookieAuth
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
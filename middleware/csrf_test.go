@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/utils"
+)
+
+func TestJWTAuthCSRF(t *testing.T) {
+	authenticator, _, _ := newTestAuthenticator(&models.User{ID: 1, Email: "test@example.com"})
+	token, err := utils.IssueToken(utils.JWTClaims{UserID: 1, Email: "test@example.com"}, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		bearer         bool
+		csrfCookie     string
+		csrfHeader     string
+		wantStatus     int
+		wantCookieAuth bool
+	}{
+		{"Cookie POST with matching token", http.MethodPost, false, "csrf", "csrf", http.StatusOK, true},
+		{"Cookie POST without header", http.MethodPost, false, "csrf", "", http.StatusForbidden, false},
+		{"Cookie POST with mismatched token", http.MethodPost, false, "csrf", "other", http.StatusForbidden, false},
+		{"Cookie POST without CSRF cookie", http.MethodPost, false, "", "csrf", http.StatusForbidden, false},
+		{"Cookie DELETE without header", http.MethodDelete, false, "csrf", "", http.StatusForbidden, false},
+		{"Cookie GET without header", http.MethodGet, false, "", "", http.StatusOK, true},
+		{"Bearer POST skips the check", http.MethodPost, true, "", "", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/tasks", nil)
+			if tt.bearer {
+				r.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				r.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: token})
+			}
+			if tt.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				r.Header.Set(CSRFHeader, tt.csrfHeader)
+			}
+
+			cookieAuth := false
+			w := httptest.NewRecorder()
+			authenticator.JWTAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cookieAuth = IsCookieAuth(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if cookieAuth != tt.wantCookieAuth {
+				t.Errorf("IsCookieAuth() = %v, want %v", cookieAuth, tt.wantCookieAuth)
+			}
+		})
+	}
+}
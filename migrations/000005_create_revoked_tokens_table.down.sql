@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN token_version;
+
+DROP INDEX IF EXISTS idx_revoked_tokens_expires_at;
+DROP INDEX IF EXISTS idx_revoked_tokens_user_id;
+DROP TABLE IF EXISTS revoked_tokens;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Accounts created before verification existed are treated as verified, so
+-- that enabling EMAIL_VERIFICATION_MODE does not lock them out
+UPDATE users SET email_verified_at = COALESCE(created_at, CURRENT_TIMESTAMP);
@@ -0,0 +1,20 @@
+-- Audit events are append-only. They reference users without a foreign key
+-- so that the trail outlives purged accounts.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    actor_user_id INTEGER,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id BIGINT,
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '{}',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) UNIQUE NOT NULL
+);
+
+CREATE INDEX idx_audit_events_actor_user_id ON audit_events(actor_user_id);
+CREATE INDEX idx_audit_events_action ON audit_events(action);
+CREATE INDEX idx_audit_events_target ON audit_events(target_type, target_id);
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_audit_events_impersonator_user_id;
+
+ALTER TABLE audit_events DROP COLUMN IF EXISTS impersonator_user_id;
@@ -0,0 +1,4 @@
+-- Set on events performed by an admin impersonating the actor
+ALTER TABLE audit_events ADD COLUMN impersonator_user_id INTEGER;
+
+CREATE INDEX idx_audit_events_impersonator_user_id ON audit_events(impersonator_user_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tasks_user_id_due_at;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS all_day;
+ALTER TABLE tasks DROP COLUMN IF EXISTS due_at;
@@ -0,0 +1,5 @@
+-- All-day tasks store the due date at midnight UTC
+ALTER TABLE tasks ADD COLUMN due_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE tasks ADD COLUMN all_day BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_tasks_user_id_due_at ON tasks(user_id, due_at);
@@ -0,0 +1,5 @@
+-- Priority is stored as its ordinal (0 none, 1 low, 2 medium, 3 high,
+-- 4 urgent) so that it sorts by importance
+ALTER TABLE tasks ADD COLUMN priority SMALLINT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_tasks_user_id_priority ON tasks(user_id, priority);
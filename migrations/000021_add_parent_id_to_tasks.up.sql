@@ -0,0 +1,5 @@
+-- Subtasks point to their parent. Deleting a task in the application
+-- soft-deletes its subtree; the cascade covers tasks purged for good.
+ALTER TABLE tasks ADD COLUMN parent_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_tasks_parent_id ON tasks(parent_id);
@@ -0,0 +1,12 @@
+-- task_id cannot be started until depends_on_id is completed
+CREATE TABLE IF NOT EXISTS task_dependencies (
+    task_id INTEGER NOT NULL,
+    depends_on_id INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (task_id, depends_on_id),
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    FOREIGN KEY (depends_on_id) REFERENCES tasks(id) ON DELETE CASCADE,
+    CHECK (task_id <> depends_on_id)
+);
+
+CREATE INDEX idx_task_dependencies_depends_on_id ON task_dependencies(depends_on_id);
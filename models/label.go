@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrLabelNameTaken is returned when the user already has a label with the
+// same name, ignoring case
+var ErrLabelNameTaken = errors.New("label name is already taken")
+
+// DefaultLabelColor is used for labels created without a color
+const DefaultLabelColor = "#9e9e9e"
+
+// Label categorizes tasks of its owner. Tasks and labels are linked through
+// the task_labels table.
+type Label struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null" json:"-"`
+	Name      string    `gorm:"not null" json:"name"`
+	Color     string    `gorm:"not null" json:"color"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type LabelRepository interface {
+	CreateLabel(label *Label) error
+	GetLabelByID(id, userID uint) (*Label, error)
+	GetLabelsByUserID(userID uint) ([]Label, error)
+	GetLabelsByIDs(userID uint, ids []uint) ([]Label, error)
+	UpdateLabel(label *Label) error
+	DeleteLabel(id, userID uint) (bool, error)
+}
+
+type labelRepository struct {
+	db *gorm.DB
+}
+
+func NewLabelRepository(db *gorm.DB) LabelRepository {
+	return &labelRepository{db: db}
+}
+
+func (r *labelRepository) CreateLabel(label *Label) error {
+	return translateLabelError(r.db.Create(label).Error)
+}
+
+// GetLabelByID returns the label if it belongs to the user
+func (r *labelRepository) GetLabelByID(id, userID uint) (*Label, error) {
+	var label Label
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&label).Error
+	if err != nil {
+		return nil, err
+	}
+	return &label, nil
+}
+
+func (r *labelRepository) GetLabelsByUserID(userID uint) ([]Label, error) {
+	var labels []Label
+	err := r.db.Where("user_id = ?", userID).Order("LOWER(name)").Find(&labels).Error
+	return labels, err
+}
+
+// GetLabelsByIDs returns those of the labels that belong to the user
+func (r *labelRepository) GetLabelsByIDs(userID uint, ids []uint) ([]Label, error) {
+	labels := []Label{}
+	if len(ids) == 0 {
+		return labels, nil
+	}
+	err := r.db.Where("user_id = ? AND id IN ?", userID, ids).Order("LOWER(name)").Find(&labels).Error
+	return labels, err
+}
+
+func (r *labelRepository) UpdateLabel(label *Label) error {
+	return translateLabelError(r.db.Save(label).Error)
+}
+
+// DeleteLabel deletes the label and removes it from all tasks. It reports
+// false when the user has no such label.
+func (r *labelRepository) DeleteLabel(id, userID uint) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&Label{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func translateLabelError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrLabelNameTaken
+	}
+	return err
+}
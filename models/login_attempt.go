@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Login attempts are tracked per account and per client address. The key is
+// one of these prefixes followed by the normalized email or the IP.
+// Magic link and password reset requests are tracked per normalized email
+// under their own prefixes, whether or not the email is registered.
+const (
+	LoginAttemptKeyEmail         = "email:"
+	LoginAttemptKeyIP            = "ip:"
+	LoginAttemptKeyMagicLink     = "magic_link:"
+	LoginAttemptKeyPasswordReset = "password_reset:"
+)
+
+// LoginAttempt counts the consecutive failed logins for a key. The counter
+// starts over once the last failure is older than the tracking window.
+type LoginAttempt struct {
+	Key          string    `gorm:"primaryKey" json:"key"`
+	Failures     int       `gorm:"not null;default:0" json:"failures"`
+	LastFailedAt time.Time `gorm:"not null;index" json:"last_failed_at"`
+}
+
+type LoginAttemptRepository interface {
+	GetLoginAttempts(keys ...string) ([]LoginAttempt, error)
+	RecordLoginFailure(key string, window time.Duration) (*LoginAttempt, error)
+	ClearLoginAttempts(keys ...string) (int64, error)
+	DeleteStaleLoginAttempts(window time.Duration) error
+}
+
+type loginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+func (r *loginAttemptRepository) GetLoginAttempts(keys ...string) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := r.db.Where("key IN ?", keys).Find(&attempts).Error
+	return attempts, err
+}
+
+// RecordLoginFailure atomically increments the failure counter of a key,
+// restarting it when the previous failure is older than window
+func (r *loginAttemptRepository) RecordLoginFailure(key string, window time.Duration) (*LoginAttempt, error) {
+	now := time.Now()
+	var attempt LoginAttempt
+	err := r.db.Raw(`
+		INSERT INTO login_attempts (key, failures, last_failed_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING key, failures, last_failed_at`,
+		key, now, now.Add(-window),
+	).Scan(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// ClearLoginAttempts unlocks the given keys and reports how many were tracked
+func (r *loginAttemptRepository) ClearLoginAttempts(keys ...string) (int64, error) {
+	result := r.db.Where("key IN ?", keys).Delete(&LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *loginAttemptRepository) DeleteStaleLoginAttempts(window time.Duration) error {
+	return r.db.Where("last_failed_at < ?", time.Now().Add(-window)).Delete(&LoginAttempt{}).Error
+}
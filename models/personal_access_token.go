@@ -0,0 +1,130 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PersonalAccessTokenPrefix makes personal access tokens recognisable, both
+// for JWTAuth and for secret scanners
+const PersonalAccessTokenPrefix = "tmpat_"
+
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+)
+
+// ValidScopes lists the scopes that can be granted to a personal access token
+var ValidScopes = map[string]bool{
+	ScopeTasksRead:  true,
+	ScopeTasksWrite: true,
+}
+
+// PersonalAccessToken is a long-lived, user-managed credential for scripts
+// and CI. Only a hash of the token is stored; TokenPrefix helps users tell
+// their tokens apart.
+type PersonalAccessToken struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Name        string     `gorm:"not null" json:"name"`
+	TokenHash   string     `gorm:"uniqueIndex;not null" json:"-"`
+	TokenPrefix string     `gorm:"not null" json:"token_prefix"`
+	Scopes      string     `gorm:"not null" json:"-"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ScopeList returns the granted scopes
+func (t *PersonalAccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, " ")
+}
+
+// HasScope reports whether the token was granted the scope
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive reports whether the token is neither revoked nor expired
+func (t *PersonalAccessToken) IsActive() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt)
+}
+
+type PersonalAccessTokenRepository interface {
+	CreatePersonalAccessToken(token *PersonalAccessToken) error
+	GetPersonalAccessTokensByUserID(userID uint) ([]PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(tokenHash string) (*PersonalAccessToken, error)
+	RevokePersonalAccessToken(id, userID uint) (bool, error)
+	TouchPersonalAccessToken(id uint) error
+	// WithTx returns the repository working within the transaction tx
+	WithTx(tx *gorm.DB) PersonalAccessTokenRepository
+}
+
+type personalAccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewPersonalAccessTokenRepository(db *gorm.DB) PersonalAccessTokenRepository {
+	return &personalAccessTokenRepository{db: db}
+}
+
+func (r *personalAccessTokenRepository) WithTx(tx *gorm.DB) PersonalAccessTokenRepository {
+	return &personalAccessTokenRepository{db: tx}
+}
+
+func (r *personalAccessTokenRepository) CreatePersonalAccessToken(token *PersonalAccessToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *personalAccessTokenRepository) GetPersonalAccessTokensByUserID(userID uint) ([]PersonalAccessToken, error) {
+	var tokens []PersonalAccessToken
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *personalAccessTokenRepository) GetPersonalAccessTokenByHash(tokenHash string) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokePersonalAccessToken revokes a token owned by the user and reports
+// whether one was found
+func (r *personalAccessTokenRepository) RevokePersonalAccessToken(id, userID uint) (bool, error) {
+	result := r.db.Model(&PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// TouchPersonalAccessToken records that the token was used. Writes are
+// throttled to one per minute so busy scripts do not update the row on
+// every request.
+func (r *personalAccessTokenRepository) TouchPersonalAccessToken(id uint) error {
+	now := time.Now()
+	return r.db.Model(&PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-time.Minute)).
+		Update("last_used_at", now).Error
+}
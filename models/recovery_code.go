@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a hashed one-time code that can replace a TOTP code when
+// the user has lost their authenticator
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+type RecoveryCodeRepository interface {
+	ReplaceRecoveryCodes(userID uint, codeHashes []string) error
+	ConsumeRecoveryCode(userID uint, codeHash string) (bool, error)
+	// WithTx returns the repository working within the transaction tx
+	WithTx(tx *gorm.DB) RecoveryCodeRepository
+}
+
+type recoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &recoveryCodeRepository{db: db}
+}
+
+func (r *recoveryCodeRepository) WithTx(tx *gorm.DB) RecoveryCodeRepository {
+	return &recoveryCodeRepository{db: tx}
+}
+
+func (r *recoveryCodeRepository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// ConsumeRecoveryCode atomically marks a matching unused code as used and
+// reports whether one was found
+func (r *recoveryCodeRepository) ConsumeRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if len(codeHashes) == 0 {
+		return nil
+	}
+
+	codes := make([]RecoveryCode, len(codeHashes))
+	for i, hash := range codeHashes {
+		codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+	return tx.Create(&codes).Error
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a long-lived, single-use credential exchanged for a new
+// access token. Tokens issued from the same login share a FamilyID so that
+// the whole chain can be revoked when reuse of a rotated token is detected.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	FamilyID  string     `gorm:"not null;index" json:"family_id"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type RefreshTokenRepository interface {
+	CreateRefreshToken(token *RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+	MarkRefreshTokenUsed(id uint) (bool, error)
+	RevokeRefreshTokenFamily(familyID string) error
+}
+
+type refreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) RefreshTokenRepository {
+	return &refreshTokenRepository{db: db}
+}
+
+func (r *refreshTokenRepository) CreateRefreshToken(token *RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *refreshTokenRepository) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed atomically flags a token as consumed. It reports false
+// when the token had already been used, which callers must treat as reuse.
+func (r *refreshTokenRepository) MarkRefreshTokenUsed(id uint) (bool, error) {
+	result := r.db.Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token of the family and ends the
+// session it belongs to
+func (r *refreshTokenRepository) RevokeRefreshTokenFamily(familyID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return revokeSessionsWhere(tx, "family_id = ?", familyID)
+	})
+}
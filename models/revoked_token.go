@@ -0,0 +1,124 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokedToken is an entry in the access token denylist, keyed by the
+// token's jti. Entries are only needed until the token would have expired.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;column:jti" json:"jti"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevokedTokenRepository interface {
+	RevokeToken(jti string, userID uint, expiresAt time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+	DeleteExpiredRevokedTokens() error
+}
+
+type revokedTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRevokedTokenRepository(db *gorm.DB) RevokedTokenRepository {
+	return &revokedTokenRepository{db: db}
+}
+
+func (r *revokedTokenRepository) RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	token := &RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error
+}
+
+func (r *revokedTokenRepository) IsTokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := r.db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *revokedTokenRepository) DeleteExpiredRevokedTokens() error {
+	return r.db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error
+}
+
+// notRevokedCacheTTL bounds how long a negative lookup is trusted. Revocations
+// made through this process are visible immediately; ones made by other
+// instances become visible once the cached entry expires.
+const notRevokedCacheTTL = 30 * time.Second
+
+type revocationCacheEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+type cachedRevokedTokenRepository struct {
+	RevokedTokenRepository
+	mu        sync.RWMutex
+	entries   map[string]revocationCacheEntry
+	lastSweep time.Time
+}
+
+// NewCachedRevokedTokenRepository wraps a repository with an in-memory cache
+// so that the denylist does not cost a database round trip on every request.
+func NewCachedRevokedTokenRepository(repo RevokedTokenRepository) RevokedTokenRepository {
+	return &cachedRevokedTokenRepository{
+		RevokedTokenRepository: repo,
+		entries:                make(map[string]revocationCacheEntry),
+	}
+}
+
+func (c *cachedRevokedTokenRepository) RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	if err := c.RevokedTokenRepository.RevokeToken(jti, userID, expiresAt); err != nil {
+		return err
+	}
+	c.set(jti, revocationCacheEntry{revoked: true, until: expiresAt})
+	return nil
+}
+
+func (c *cachedRevokedTokenRepository) IsTokenRevoked(jti string) (bool, error) {
+	c.mu.RLock()
+	entry, ok := c.entries[jti]
+	c.mu.RUnlock()
+	if ok && time.Now().Before(entry.until) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := c.RevokedTokenRepository.IsTokenRevoked(jti)
+	if err != nil {
+		return false, err
+	}
+
+	until := time.Now().Add(notRevokedCacheTTL)
+	if revoked {
+		// Revocations never lapse, so positive results can be kept longer.
+		until = time.Now().Add(24 * time.Hour)
+	}
+	c.set(jti, revocationCacheEntry{revoked: revoked, until: until})
+	return revoked, nil
+}
+
+func (c *cachedRevokedTokenRepository) set(jti string, entry revocationCacheEntry) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[jti] = entry
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	for key, e := range c.entries {
+		if now.After(e.until) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Session is one login of a user on a device. It lives as long as the
+// refresh token family started by the login; JTI is the ID of the most
+// recently issued access token.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	FamilyID   string     `gorm:"uniqueIndex;not null" json:"-"`
+	JTI        string     `gorm:"column:jti;not null" json:"-"`
+	UserAgent  string     `gorm:"not null" json:"user_agent"`
+	IPAddress  string     `gorm:"column:ip_address;not null" json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+type SessionRepository interface {
+	CreateSession(session *Session) error
+	GetSessionByID(id uint) (*Session, error)
+	GetSessionByFamilyID(familyID string) (*Session, error)
+	GetActiveSessionsByUserID(userID uint, since time.Time) ([]Session, error)
+	RotateSessionToken(id uint, jti, ipAddress string) error
+	TouchSession(id uint, ipAddress string) error
+	RevokeSession(id, userID uint) (*Session, error)
+	// WithTx returns the repository working within the transaction tx
+	WithTx(tx *gorm.DB) SessionRepository
+}
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) WithTx(tx *gorm.DB) SessionRepository {
+	return &sessionRepository{db: tx}
+}
+
+func (r *sessionRepository) CreateSession(session *Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *sessionRepository) GetSessionByID(id uint) (*Session, error) {
+	var session Session
+	err := r.db.First(&session, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetSessionByFamilyID(familyID string) (*Session, error) {
+	var session Session
+	err := r.db.Where("family_id = ?", familyID).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSessionsByUserID lists the sessions that are not revoked and were
+// used after since
+func (r *sessionRepository) GetActiveSessionsByUserID(userID uint, since time.Time) ([]Session, error) {
+	var sessions []Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, since).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RotateSessionToken records the access token issued by a refresh
+func (r *sessionRepository) RotateSessionToken(id uint, jti, ipAddress string) error {
+	return r.db.Model(&Session{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"jti":          jti,
+		"ip_address":   ipAddress,
+		"last_seen_at": time.Now(),
+	}).Error
+}
+
+// TouchSession records activity on the session, at most once per minute
+func (r *sessionRepository) TouchSession(id uint, ipAddress string) error {
+	now := time.Now()
+	return r.db.Model(&Session{}).
+		Where("id = ? AND last_seen_at < ?", id, now.Add(-time.Minute)).
+		Updates(map[string]interface{}{
+			"ip_address":   ipAddress,
+			"last_seen_at": now,
+		}).Error
+}
+
+// RevokeSession ends a session of the user together with its refresh tokens.
+// It returns the revoked session, or gorm.ErrRecordNotFound when the user has
+// no such active session.
+func (r *sessionRepository) RevokeSession(id, userID uint) (*Session, error) {
+	var sessions []Session
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&sessions).
+			Clauses(clause.Returning{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(sessions) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", sessions[0].FamilyID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+func revokeSessionsWhere(tx *gorm.DB, query string, args ...interface{}) error {
+	return tx.Model(&Session{}).
+		Where(query+" AND revoked_at IS NULL", args...).
+		Update("revoked_at", time.Now()).Error
+}
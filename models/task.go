@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,11 +13,85 @@ type Task struct {
 	Title       string         `gorm:"not null" json:"title"`
 	Description string         `json:"description"`
 	Status      string         `gorm:"default:'pending'" json:"status"`
+	Priority    TaskPriority   `gorm:"not null;default:0" json:"priority"`
+	DueAt       *time.Time     `json:"due_at"`
+	AllDay      bool           `gorm:"not null;default:false" json:"all_day"`
+	ParentID    *uint          `json:"parent_id"`
 	UserID      uint           `gorm:"not null" json:"user_id"`
 	User        User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Labels      []Label        `gorm:"many2many:task_labels" json:"labels"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	// Progress is only set on tasks that have subtasks
+	Progress *TaskProgress `gorm:"-" json:"progress,omitempty"`
+	// IsBlocked is set while the task depends on tasks that are not completed
+	IsBlocked bool `gorm:"-" json:"is_blocked"`
+}
+
+// TaskProgress rolls up the status of the direct subtasks of a task
+type TaskProgress struct {
+	Completed int64 `json:"completed"`
+	Total     int64 `json:"total"`
+}
+
+// MaxTaskDepth is the number of levels a task tree may have, counting the
+// top-level task
+const MaxTaskDepth = 5
+
+// TaskTree is a task with its subtasks, nested
+type TaskTree struct {
+	Task
+	Subtasks []*TaskTree `json:"subtasks"`
+}
+
+var (
+	ErrTaskParentCycle = errors.New("task cannot be moved under its own subtree")
+	ErrTaskTooDeep     = errors.New("task tree would be nested too deep")
+)
+
+// ValidateTaskParent checks that the task may be moved under the parent,
+// given the ancestors of the parent (nearest first) and the height of the
+// task's own tree. New tasks have an ID of 0 and a height of 1.
+func ValidateTaskParent(taskID, parentID uint, parentAncestorIDs []uint, height int) error {
+	if taskID != 0 && parentID == taskID {
+		return ErrTaskParentCycle
+	}
+	for _, id := range parentAncestorIDs {
+		if taskID != 0 && id == taskID {
+			return ErrTaskParentCycle
+		}
+	}
+	if len(parentAncestorIDs)+1+height > MaxTaskDepth {
+		return ErrTaskTooDeep
+	}
+	return nil
+}
+
+// Height returns the number of levels of the tree, 1 for a task without
+// subtasks
+func (t *TaskTree) Height() int {
+	height := 0
+	for _, subtask := range t.Subtasks {
+		if h := subtask.Height(); h > height {
+			height = h
+		}
+	}
+	return height + 1
+}
+
+// BlockedOpenSubtaskIDs returns the IDs of the subtasks, at any depth, that
+// are blocked and not completed yet, i.e. those that completing the task
+// would complete despite their dependencies
+func (t *TaskTree) BlockedOpenSubtaskIDs() []uint {
+	var ids []uint
+	for _, subtask := range t.Subtasks {
+		if subtask.IsBlocked && subtask.Status != TaskStatusCompleted {
+			ids = append(ids, subtask.ID)
+		}
+		ids = append(ids, subtask.BlockedOpenSubtaskIDs()...)
+	}
+	return ids
 }
 
 const (
@@ -24,12 +100,92 @@ const (
 	TaskStatusCompleted  = "completed"
 )
 
+// TaskPriority is stored as an ordinal so that higher priorities sort after
+// lower ones, and appears by name in JSON
+type TaskPriority int16
+
+const (
+	TaskPriorityNone TaskPriority = iota
+	TaskPriorityLow
+	TaskPriorityMedium
+	TaskPriorityHigh
+	TaskPriorityUrgent
+)
+
+var taskPriorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+// ParseTaskPriority returns the priority with the given name
+func ParseTaskPriority(name string) (TaskPriority, bool) {
+	for i, priorityName := range taskPriorityNames {
+		if name == priorityName {
+			return TaskPriority(i), true
+		}
+	}
+	return TaskPriorityNone, false
+}
+
+func (p TaskPriority) String() string {
+	if p < 0 || int(p) >= len(taskPriorityNames) {
+		return taskPriorityNames[TaskPriorityNone]
+	}
+	return taskPriorityNames[p]
+}
+
+func (p TaskPriority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// TaskDateLayout is the format of all-day due dates
+const TaskDateLayout = "2006-01-02"
+
+// IsOverdue reports whether the task is still open past its due time. An
+// all-day task is due by the end of its date in the time zone of now, which
+// should be the user's.
+func (t *Task) IsOverdue(now time.Time) bool {
+	if t.DueAt == nil || t.Status == TaskStatusCompleted {
+		return false
+	}
+	if t.AllDay {
+		return t.DueAt.Before(taskToday(now))
+	}
+	return !now.Before(*t.DueAt)
+}
+
+// taskToday returns the date of now in its time zone the way all-day due
+// dates are stored, at midnight UTC
+func taskToday(now time.Time) time.Time {
+	year, month, day := now.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// TaskSortFields lists the fields tasks can be sorted by
+var TaskSortFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"title":      true,
+	"status":     true,
+	"due_at":     true,
+	"priority":   true,
+}
+
+// TaskSort is one key of a task ordering
+type TaskSort struct {
+	Field string // one of TaskSortFields
+	Desc  bool
+}
+
 type TaskFilter struct {
-	Status  string
-	Page    int
-	Limit   int
-	SortBy  string // created_at, updated_at, title, status
-	Order   string // asc, desc
+	Status     string
+	Priorities []TaskPriority // any of
+	DueBefore  *time.Time     // due strictly before
+	DueAfter   *time.Time     // due at or after
+	Overdue    *bool
+	Location   *time.Location // time zone all-day tasks become overdue in; UTC when nil
+	Labels     []string // label names, lowercase
+	AllLabels  bool     // match tasks with all of Labels rather than any
+	Page       int
+	Limit      int
+	Sort       []TaskSort // applied in order; newest first when empty
 }
 
 type TasksResponse struct {
@@ -40,12 +196,28 @@ type TasksResponse struct {
 	TotalPages int    `json:"total_pages"`
 }
 
+// TaskCounts summarises the tasks of a user by status
+type TaskCounts struct {
+	Total      int64 `json:"total"`
+	Pending    int64 `json:"pending"`
+	InProgress int64 `json:"in_progress"`
+	Completed  int64 `json:"completed"`
+}
+
 type TaskRepository interface {
 	CreateTask(task *Task) error
 	GetTaskByID(id uint) (*Task, error)
 	GetTasksByUserID(userID uint, filter TaskFilter) (*TasksResponse, error)
-	UpdateTask(task *Task) error
-	DeleteTask(id uint) error
+	GetSubtasks(parentID uint) ([]Task, error)
+	GetTaskTree(id uint) (*TaskTree, error)
+	GetTaskAncestorIDs(id uint) ([]uint, error)
+	GetTaskDependencies(id uint) (*TaskDependencies, error)
+	AddTaskDependency(taskID, dependsOnID uint) error
+	RemoveTaskDependency(taskID, dependsOnID uint) (bool, error)
+	UpdateTask(task *Task) ([]Task, error)
+	DeleteTask(id uint) ([]Task, error)
+	CountTasksByUserID(userID uint) (*TaskCounts, error)
+	CountTasksDeletedWithUser(userID uint, deletedAt time.Time) (*TaskCounts, error)
 }
 
 type taskRepository struct {
@@ -56,34 +228,202 @@ func NewTaskRepository(db *gorm.DB) TaskRepository {
 	return &taskRepository{db: db}
 }
 
+// taskLabel is a row of the join table between tasks and labels
+type taskLabel struct {
+	TaskID  uint
+	LabelID uint
+}
+
+func (taskLabel) TableName() string {
+	return "task_labels"
+}
+
+// preloadTask loads the associations returned with tasks. Labels of all the
+// tasks found are loaded with a single query.
+func preloadTask(db *gorm.DB) *gorm.DB {
+	return db.Preload("User").Preload("Labels", func(db *gorm.DB) *gorm.DB {
+		return db.Order("LOWER(labels.name)")
+	})
+}
+
+// CreateTask stores the task and links it to its Labels, which must already
+// exist
 func (r *taskRepository) CreateTask(task *Task) error {
-	if err := r.db.Create(task).Error; err != nil {
+	if err := r.db.Omit("Labels.*").Create(task).Error; err != nil {
 		return err
 	}
 	// Reload the task with user data
-	return r.db.Preload("User").First(task, task.ID).Error
+	return r.reload(task)
 }
 
 func (r *taskRepository) GetTaskByID(id uint) (*Task, error) {
 	var task Task
-	err := r.db.Preload("User").First(&task, id).Error
+	err := preloadTask(r.db).First(&task, id).Error
 	if err != nil {
 		return nil, err
 	}
+	if err := r.annotate(&task); err != nil {
+		return nil, err
+	}
 	return &task, nil
 }
 
+// reload reads back the stored task with its associations
+func (r *taskRepository) reload(task *Task) error {
+	if err := preloadTask(r.db).First(task, task.ID).Error; err != nil {
+		return err
+	}
+	return r.annotate(task)
+}
+
+// annotate sets the computed fields of the tasks, with one query per field
+// for all of them
+func (r *taskRepository) annotate(tasks ...*Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+
+	if err := r.setProgress(tasks, ids); err != nil {
+		return err
+	}
+	return r.setBlocked(tasks, ids)
+}
+
+// setProgress sets the Progress of those of the tasks that have subtasks
+func (r *taskRepository) setProgress(tasks []*Task, ids []uint) error {
+	var rows []struct {
+		ParentID  uint
+		Completed int64
+		Total     int64
+	}
+	err := r.db.Model(&Task{}).
+		Select("parent_id, COUNT(*) FILTER (WHERE status = ?) AS completed, COUNT(*) AS total", TaskStatusCompleted).
+		Where("parent_id IN ?", ids).
+		Group("parent_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	progress := make(map[uint]*TaskProgress, len(rows))
+	for _, row := range rows {
+		progress[row.ParentID] = &TaskProgress{Completed: row.Completed, Total: row.Total}
+	}
+	for _, task := range tasks {
+		task.Progress = progress[task.ID]
+	}
+	return nil
+}
+
+// setBlocked sets IsBlocked of the tasks that depend on an unfinished task.
+// Deleted tasks do not block anything.
+func (r *taskRepository) setBlocked(tasks []*Task, ids []uint) error {
+	var blockedIDs []uint
+	err := r.db.Raw(`SELECT DISTINCT d.task_id FROM task_dependencies d
+		JOIN tasks b ON b.id = d.depends_on_id
+		WHERE d.task_id IN ? AND b.status <> ? AND b.deleted_at IS NULL`, ids, TaskStatusCompleted).
+		Scan(&blockedIDs).Error
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[uint]bool, len(blockedIDs))
+	for _, id := range blockedIDs {
+		blocked[id] = true
+	}
+	for _, task := range tasks {
+		task.IsBlocked = blocked[task.ID]
+	}
+	return nil
+}
+
+// taskPointers returns pointers to the elements of tasks
+func taskPointers(tasks []Task) []*Task {
+	pointers := make([]*Task, len(tasks))
+	for i := range tasks {
+		pointers[i] = &tasks[i]
+	}
+	return pointers
+}
+
+// subtreeSQL selects the IDs and depths (1 for the root) of a task and its
+// descendants. The depth bound also stops the walk should the stored tree
+// ever contain a cycle.
+const subtreeSQL = `WITH RECURSIVE subtree AS (
+	SELECT id, 1 AS depth FROM tasks WHERE id = ? AND deleted_at IS NULL
+	UNION ALL
+	SELECT t.id, s.depth + 1 FROM tasks t JOIN subtree s ON t.parent_id = s.id
+	WHERE t.deleted_at IS NULL AND s.depth < ?
+) SELECT id FROM subtree`
+
+func (r *taskRepository) subtreeIDs(db *gorm.DB, id uint) *gorm.DB {
+	return db.Raw(subtreeSQL, id, MaxTaskDepth)
+}
+
+// GetSubtasks returns the direct subtasks of a task, oldest first
+func (r *taskRepository) GetSubtasks(parentID uint) ([]Task, error) {
+	var tasks []Task
+	err := preloadTask(r.db).Where("parent_id = ?", parentID).Order("created_at ASC, id ASC").Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, r.annotate(taskPointers(tasks)...)
+}
+
+// GetTaskTree returns the task with all its descendants
+func (r *taskRepository) GetTaskTree(id uint) (*TaskTree, error) {
+	var tasks []Task
+	err := preloadTask(r.db).Where("id IN (?)", r.subtreeIDs(r.db, id)).Order("created_at ASC, id ASC").Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := r.annotate(taskPointers(tasks)...); err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*TaskTree, len(tasks))
+	for _, task := range tasks {
+		nodes[task.ID] = &TaskTree{Task: task, Subtasks: []*TaskTree{}}
+	}
+	root, ok := nodes[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	for _, task := range tasks {
+		if task.ID == id || task.ParentID == nil {
+			continue
+		}
+		if parent, ok := nodes[*task.ParentID]; ok {
+			parent.Subtasks = append(parent.Subtasks, nodes[task.ID])
+		}
+	}
+	return root, nil
+}
+
+// GetTaskAncestorIDs returns the IDs of the parent of a task, its parent and
+// so on up to the top-level task
+func (r *taskRepository) GetTaskAncestorIDs(id uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.Raw(`WITH RECURSIVE ancestors AS (
+		SELECT parent_id, 1 AS depth FROM tasks WHERE id = ?
+		UNION ALL
+		SELECT t.parent_id, a.depth + 1 FROM tasks t JOIN ancestors a ON t.id = a.parent_id
+		WHERE a.depth < ?
+	) SELECT parent_id FROM ancestors WHERE parent_id IS NOT NULL ORDER BY depth`, id, MaxTaskDepth).
+		Scan(&ids).Error
+	return ids, err
+}
+
 func (r *taskRepository) GetTasksByUserID(userID uint, filter TaskFilter) (*TasksResponse, error) {
 	var tasks []Task
 	var total int64
 
 	// Base query
-	baseQuery := r.db.Model(&Task{}).Where("user_id = ?", userID)
-
-	// Apply status filter
-	if filter.Status != "" {
-		baseQuery = baseQuery.Where("status = ?", filter.Status)
-	}
+	baseQuery := applyTaskFilter(r.db.Model(&Task{}).Where("user_id = ?", userID), filter)
 
 	// Count total records
 	if err := baseQuery.Count(&total).Error; err != nil {
@@ -105,36 +445,21 @@ func (r *taskRepository) GetTasksByUserID(userID uint, filter TaskFilter) (*Task
 	offset := (filter.Page - 1) * filter.Limit
 
 	// Build query with preload
-	query := r.db.Preload("User").Where("user_id = ?", userID)
-
-	// Apply status filter
-	if filter.Status != "" {
-		query = query.Where("status = ?", filter.Status)
-	}
+	query := applyTaskFilter(preloadTask(r.db).Where("user_id = ?", userID), filter)
 
 	// Apply sorting
-	orderBy := "created_at DESC" // default
-	if filter.SortBy != "" {
-		validSortFields := map[string]bool{
-			"created_at": true,
-			"updated_at": true,
-			"title":      true,
-			"status":     true,
-		}
-		if validSortFields[filter.SortBy] {
-			order := "DESC"
-			if filter.Order == "asc" {
-				order = "ASC"
-			}
-			orderBy = filter.SortBy + " " + order
-		}
+	for _, orderBy := range taskOrder(filter.Sort) {
+		query = query.Order(orderBy)
 	}
 
 	// Apply pagination and sorting
-	err := query.Order(orderBy).Limit(filter.Limit).Offset(offset).Find(&tasks).Error
+	err := query.Limit(filter.Limit).Offset(offset).Find(&tasks).Error
 	if err != nil {
 		return nil, err
 	}
+	if err := r.annotate(taskPointers(tasks)...); err != nil {
+		return nil, err
+	}
 
 	// Calculate total pages
 	totalPages := int(total) / filter.Limit
@@ -151,14 +476,169 @@ func (r *taskRepository) GetTasksByUserID(userID uint, filter TaskFilter) (*Task
 	}, nil
 }
 
-func (r *taskRepository) UpdateTask(task *Task) error {
-	if err := r.db.Save(task).Error; err != nil {
-		return err
+// taskOrder returns the ORDER BY terms of a task ordering
+func taskOrder(sort []TaskSort) []string {
+	var terms []string
+	for _, key := range sort {
+		if !TaskSortFields[key.Field] {
+			continue
+		}
+		orderBy := key.Field + " ASC"
+		if key.Desc {
+			orderBy = key.Field + " DESC"
+		}
+		// Tasks without a due date come last in either direction
+		if key.Field == "due_at" {
+			orderBy += " NULLS LAST"
+		}
+		terms = append(terms, orderBy)
+	}
+	if len(terms) == 0 {
+		terms = append(terms, "created_at DESC") // default
+	}
+	// Keep pages stable when the sort keys tie
+	return append(terms, "id DESC")
+}
+
+// applyTaskFilter narrows a task query down to the tasks matching the filter
+func applyTaskFilter(query *gorm.DB, filter TaskFilter) *gorm.DB {
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if len(filter.Priorities) > 0 {
+		query = query.Where("priority IN ?", filter.Priorities)
+	}
+	if filter.DueBefore != nil {
+		query = query.Where("due_at < ?", *filter.DueBefore)
+	}
+	if filter.DueAfter != nil {
+		query = query.Where("due_at >= ?", *filter.DueAfter)
+	}
+	if filter.Overdue != nil {
+		// Mirrors Task.IsOverdue
+		now := time.Now()
+		if filter.Location != nil {
+			now = now.In(filter.Location)
+		}
+		overdue := "status <> ? AND due_at IS NOT NULL AND " +
+			"CASE WHEN all_day THEN due_at < ? ELSE due_at <= ? END"
+		if !*filter.Overdue {
+			overdue = "NOT (" + overdue + ")"
+		}
+		query = query.Where(overdue, TaskStatusCompleted, taskToday(now), now)
+	}
+	if len(filter.Labels) > 0 {
+		labeled := "SELECT tl.task_id FROM task_labels tl JOIN labels l ON l.id = tl.label_id " +
+			"WHERE LOWER(l.name) IN ?"
+		if filter.AllLabels {
+			// A user's label names are unique, so a task has all of them when
+			// it has as many matching labels as there are names
+			query = query.Where("id IN ("+labeled+" GROUP BY tl.task_id HAVING COUNT(*) = ?)",
+				filter.Labels, len(filter.Labels))
+		} else {
+			query = query.Where("id IN ("+labeled+")", filter.Labels)
+		}
+	}
+	return query
+}
+
+// UpdateTask saves the task and replaces its labels with its Labels. A
+// completed task completes all its open subtasks, at any depth; those are
+// returned as they were before.
+func (r *taskRepository) UpdateTask(task *Task) ([]Task, error) {
+	var completed []Task
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Labels").Save(task).Error; err != nil {
+			return err
+		}
+		if task.Status == TaskStatusCompleted {
+			err := preloadTask(tx).
+				Where("id IN (?) AND status <> ?", r.subtreeIDs(tx, task.ID), TaskStatusCompleted).
+				Find(&completed).Error
+			if err != nil {
+				return err
+			}
+			if len(completed) > 0 {
+				ids := make([]uint, len(completed))
+				for i, subtask := range completed {
+					ids[i] = subtask.ID
+				}
+				err := tx.Model(&Task{}).Where("id IN ?", ids).Update("status", TaskStatusCompleted).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&taskLabel{}).Error; err != nil {
+			return err
+		}
+		if len(task.Labels) == 0 {
+			return nil
+		}
+		rows := make([]taskLabel, len(task.Labels))
+		for i, label := range task.Labels {
+			rows[i] = taskLabel{TaskID: task.ID, LabelID: label.ID}
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 	// Reload the task with user data
-	return r.db.Preload("User").First(task, task.ID).Error
+	return completed, r.reload(task)
 }
 
-func (r *taskRepository) DeleteTask(id uint) error {
-	return r.db.Delete(&Task{}, id).Error
+// DeleteTask deletes the task together with all its subtasks, which are
+// returned as they were before
+func (r *taskRepository) DeleteTask(id uint) ([]Task, error) {
+	var subtasks []Task
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := preloadTask(tx).Where("id IN (?) AND id <> ?", r.subtreeIDs(tx, id), id).Find(&subtasks).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("id IN (?)", r.subtreeIDs(tx, id)).Delete(&Task{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subtasks, nil
+}
+
+func (r *taskRepository) CountTasksByUserID(userID uint) (*TaskCounts, error) {
+	return countTasks(r.db.Model(&Task{}).Where("user_id = ?", userID))
+}
+
+// CountTasksDeletedWithUser counts the tasks of a deleted user that were
+// deleted along with the account, i.e. those RestoreUser brings back
+func (r *taskRepository) CountTasksDeletedWithUser(userID uint, deletedAt time.Time) (*TaskCounts, error) {
+	return countTasks(r.db.Unscoped().Model(&Task{}).Where("user_id = ? AND deleted_at = ?", userID, deletedAt))
+}
+
+func countTasks(query *gorm.DB) (*TaskCounts, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := query.
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := &TaskCounts{}
+	for _, row := range rows {
+		counts.Total += row.Count
+		switch row.Status {
+		case TaskStatusPending:
+			counts.Pending = row.Count
+		case TaskStatusInProgress:
+			counts.InProgress = row.Count
+		case TaskStatusCompleted:
+			counts.Completed = row.Count
+		}
+	}
+	return counts, nil
 }
\ No newline at end of file
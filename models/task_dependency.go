@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTaskDependencyExists = errors.New("task dependency already exists")
+	ErrTaskDependencyCycle  = errors.New("task dependency would create a cycle")
+)
+
+// taskDependencyLockKey serializes changes to dependencies so that two
+// concurrent links cannot close a cycle between them
+const taskDependencyLockKey = 7420152
+
+// TaskDependency records that TaskID cannot start until DependsOnID is
+// completed
+type TaskDependency struct {
+	TaskID      uint `gorm:"primaryKey"`
+	DependsOnID uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+}
+
+// TaskDependencies lists the tasks a task is blocked by and the tasks it
+// blocks
+type TaskDependencies struct {
+	BlockedBy []Task `json:"blocked_by"`
+	Blocks    []Task `json:"blocks"`
+}
+
+// GetTaskDependencies returns the tasks linked to the task in either direction
+func (r *taskRepository) GetTaskDependencies(id uint) (*TaskDependencies, error) {
+	dependencies := &TaskDependencies{}
+	err := preloadTask(r.db).
+		Where("id IN (SELECT depends_on_id FROM task_dependencies WHERE task_id = ?)", id).
+		Order("created_at ASC, id ASC").
+		Find(&dependencies.BlockedBy).Error
+	if err != nil {
+		return nil, err
+	}
+	err = preloadTask(r.db).
+		Where("id IN (SELECT task_id FROM task_dependencies WHERE depends_on_id = ?)", id).
+		Order("created_at ASC, id ASC").
+		Find(&dependencies.Blocks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := append(taskPointers(dependencies.BlockedBy), taskPointers(dependencies.Blocks)...)
+	if err := r.annotate(tasks...); err != nil {
+		return nil, err
+	}
+	return dependencies, nil
+}
+
+// AddTaskDependency makes taskID depend on dependsOnID. It fails with
+// ErrTaskDependencyCycle when dependsOnID already depends on taskID, directly
+// or through other tasks.
+func (r *taskRepository) AddTaskDependency(taskID, dependsOnID uint) error {
+	if taskID == dependsOnID {
+		return ErrTaskDependencyCycle
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", taskDependencyLockKey).Error; err != nil {
+			return err
+		}
+
+		var cycle bool
+		err := tx.Raw(`WITH RECURSIVE upstream AS (
+			SELECT depends_on_id AS id FROM task_dependencies WHERE task_id = ?
+			UNION
+			SELECT d.depends_on_id FROM task_dependencies d JOIN upstream u ON d.task_id = u.id
+		) SELECT EXISTS (SELECT 1 FROM upstream WHERE id = ?)`, dependsOnID, taskID).
+			Scan(&cycle).Error
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrTaskDependencyCycle
+		}
+
+		err = tx.Create(&TaskDependency{TaskID: taskID, DependsOnID: dependsOnID}).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrTaskDependencyExists
+		}
+		return err
+	})
+}
+
+// RemoveTaskDependency removes the link, reporting false when there was none
+func (r *taskRepository) RemoveTaskDependency(taskID, dependsOnID uint) (bool, error) {
+	result := r.db.Where("task_id = ? AND depends_on_id = ?", taskID, dependsOnID).Delete(&TaskDependency{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTaskTreeHeight(t *testing.T) {
+	leaf := func() *TaskTree { return &TaskTree{Subtasks: []*TaskTree{}} }
+
+	tests := []struct {
+		name string
+		tree *TaskTree
+		want int
+	}{
+		{"Task without subtasks", leaf(), 1},
+		{"Task with subtasks", &TaskTree{Subtasks: []*TaskTree{leaf(), leaf()}}, 2},
+		{
+			"Uneven branches",
+			&TaskTree{Subtasks: []*TaskTree{
+				leaf(),
+				{Subtasks: []*TaskTree{{Subtasks: []*TaskTree{leaf()}}}},
+			}},
+			4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tree.Height(); got != tt.want {
+				t.Errorf("Height() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTaskParent(t *testing.T) {
+	tests := []struct {
+		name              string
+		taskID            uint
+		parentID          uint
+		parentAncestorIDs []uint
+		height            int
+		want              error
+	}{
+		{"New task under top-level task", 0, 1, nil, 1, nil},
+		{"Existing task under top-level task", 5, 1, nil, 1, nil},
+		{"Task under itself", 5, 5, nil, 1, ErrTaskParentCycle},
+		{"Task under its subtask", 5, 7, []uint{5}, 2, ErrTaskParentCycle},
+		{"Task under a deeper descendant", 5, 9, []uint{7, 5, 1}, 3, ErrTaskParentCycle},
+		{"Deepest allowed level", 0, 4, []uint{3, 2, 1}, 1, nil},
+		{"New task below the deepest level", 0, 5, []uint{4, 3, 2, 1}, 1, ErrTaskTooDeep},
+		{"Subtree taller than the room left", 8, 3, []uint{2, 1}, 3, ErrTaskTooDeep},
+		{"Subtree that just fits", 8, 3, []uint{2, 1}, 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateTaskParent(tt.taskID, tt.parentID, tt.parentAncestorIDs, tt.height); got != tt.want {
+				t.Errorf("ValidateTaskParent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskTreeBlockedOpenSubtaskIDs(t *testing.T) {
+	node := func(id uint, status string, blocked bool, subtasks ...*TaskTree) *TaskTree {
+		return &TaskTree{
+			Task:     Task{ID: id, Status: status, IsBlocked: blocked},
+			Subtasks: subtasks,
+		}
+	}
+
+	t.Run("Blocked open subtasks refuse the cascade", func(t *testing.T) {
+		tree := node(1, TaskStatusPending, false,
+			node(2, TaskStatusPending, false,
+				node(4, TaskStatusInProgress, true),
+			),
+			node(3, TaskStatusPending, true),
+		)
+		got := tree.BlockedOpenSubtaskIDs()
+		if len(got) != 2 || got[0] != 4 || got[1] != 3 {
+			t.Errorf("BlockedOpenSubtaskIDs() = %v, want [4 3]", got)
+		}
+	})
+
+	t.Run("Unblocked or completed subtasks allow the cascade", func(t *testing.T) {
+		tree := node(1, TaskStatusPending, true,
+			node(2, TaskStatusPending, false),
+			node(3, TaskStatusCompleted, true),
+		)
+		if got := tree.BlockedOpenSubtaskIDs(); len(got) != 0 {
+			t.Errorf("BlockedOpenSubtaskIDs() = %v, want none", got)
+		}
+	})
+}
+
+func TestParseTaskPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   TaskPriority
+		wantOk bool
+	}{
+		{"none", TaskPriorityNone, true},
+		{"low", TaskPriorityLow, true},
+		{"medium", TaskPriorityMedium, true},
+		{"high", TaskPriorityHigh, true},
+		{"urgent", TaskPriorityUrgent, true},
+		{"High", TaskPriorityNone, false},
+		{"", TaskPriorityNone, false},
+		{"critical", TaskPriorityNone, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseTaskPriority(tt.name)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("ParseTaskPriority(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOk)
+			}
+			if ok && got.String() != tt.name {
+				t.Errorf("String() = %q, want %q", got.String(), tt.name)
+			}
+		})
+	}
+}
+
+func TestTaskIsOverdue(t *testing.T) {
+	now := time.Date(2025, 3, 2, 12, 0, 0, 0, time.UTC)
+	at := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name string
+		task Task
+		want bool
+	}{
+		{"No due date", Task{Status: TaskStatusPending}, false},
+		{"Due in the future", Task{Status: TaskStatusPending, DueAt: at(now.Add(time.Hour))}, false},
+		{"Due in the past", Task{Status: TaskStatusInProgress, DueAt: at(now.Add(-time.Hour))}, true},
+		{"Due right now", Task{Status: TaskStatusPending, DueAt: at(now)}, true},
+		{"Completed past its due time", Task{Status: TaskStatusCompleted, DueAt: at(now.Add(-time.Hour))}, false},
+		{
+			"All-day task due today",
+			Task{Status: TaskStatusPending, AllDay: true, DueAt: at(time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC))},
+			false,
+		},
+		{
+			"All-day task due yesterday",
+			Task{Status: TaskStatusPending, AllDay: true, DueAt: at(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.task.IsOverdue(now); got != tt.want {
+				t.Errorf("IsOverdue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskIsOverdueInTimeZone(t *testing.T) {
+	// 2025-03-01 is still today west of UTC and already over east of it
+	now := time.Date(2025, 3, 2, 3, 0, 0, 0, time.UTC)
+	due := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	task := Task{Status: TaskStatusPending, AllDay: true, DueAt: &due}
+
+	tests := []struct {
+		name   string
+		offset int
+		want   bool
+	}{
+		{"UTC", 0, true},
+		{"West of UTC", -5 * 60 * 60, false},
+		{"East of UTC", 7 * 60 * 60, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := now.In(time.FixedZone("", tt.offset))
+			if got := task.IsOverdue(local); got != tt.want {
+				t.Errorf("IsOverdue(%v) = %v, want %v", local, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		sort []TaskSort
+		want []string
+	}{
+		{"Default", nil, []string{"created_at DESC", "id DESC"}},
+		{"Single field", []TaskSort{{Field: "title"}}, []string{"title ASC", "id DESC"}},
+		{"Due date ascending", []TaskSort{{Field: "due_at"}}, []string{"due_at ASC NULLS LAST", "id DESC"}},
+		{"Due date descending", []TaskSort{{Field: "due_at", Desc: true}}, []string{"due_at DESC NULLS LAST", "id DESC"}},
+		{
+			"Several fields",
+			[]TaskSort{{Field: "priority", Desc: true}, {Field: "due_at"}},
+			[]string{"priority DESC", "due_at ASC NULLS LAST", "id DESC"},
+		},
+		{"Unknown field", []TaskSort{{Field: "password"}}, []string{"created_at DESC", "id DESC"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := taskOrder(tt.sort); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("taskOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
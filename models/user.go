@@ -1,26 +1,102 @@
 package models
 
 import (
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/hrusfandi/sb-task-management/utils"
 	"gorm.io/gorm"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// ErrEmailTaken is returned when an email, once normalized, already belongs
+// to another account
+var ErrEmailTaken = errors.New("email is already taken")
+
+// ValidRoles lists the roles a user can be given
+var ValidRoles = map[string]bool{
+	RoleUser:  true,
+	RoleAdmin: true,
+}
+
 type User struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Name      string         `gorm:"not null" json:"name"`
-	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
-	Password  string         `gorm:"not null" json:"-"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	Tasks     []Task         `gorm:"foreignKey:UserID" json:"tasks,omitempty"`
+	ID                    uint           `gorm:"primaryKey" json:"id"`
+	Name                  string         `gorm:"not null" json:"name"`
+	Email                 string         `gorm:"uniqueIndex;not null" json:"email"`
+	Password              string         `gorm:"not null" json:"-"`
+	Role                  string         `gorm:"not null;default:user" json:"role"`
+	TokenVersion          int            `gorm:"not null;default:0" json:"-"`
+	EmailVerifiedAt       *time.Time     `json:"email_verified_at"`
+	PendingEmail          *string        `json:"pending_email,omitempty"`
+	TOTPSecret            string         `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabledAt         *time.Time     `gorm:"column:totp_enabled_at" json:"-"`
+	TOTPLastStep          int64          `gorm:"column:totp_last_step;not null;default:0" json:"-"`
+	SuspendedAt           *time.Time     `json:"suspended_at,omitempty"`
+	PasswordResetRequired bool           `gorm:"not null;default:false" json:"password_reset_required,omitempty"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt `gorm:"index" json:"-"`
+	Tasks                 []Task         `gorm:"foreignKey:UserID" json:"tasks,omitempty"`
 }
 
 type UserRepository interface {
 	CreateUser(user *User) error
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id uint) (*User, error)
+	IsEmailTaken(email string) (bool, error)
+	UpdateProfile(id uint, name, pendingEmail *string) error
+	ConfirmEmailChange(id uint, email string) (bool, error)
+	SoftDeleteUser(id uint) error
+	UpdatePassword(id uint, hashedPassword string) error
+	RehashPassword(id uint, oldHash, newHash string) error
+	RevokeAllTokens(id uint) error
+	MarkEmailVerified(id uint) error
+	SetTOTPSecret(id uint, secret string) error
+	EnableTOTP(id uint, recoveryCodeHashes []string) error
+	DisableTOTP(id uint) error
+	UseTOTPStep(id uint, step int64) (bool, error)
+	SetRole(id uint, role string) error
+	ListUsers(filter UserFilter) (*UsersResponse, error)
+	GetUserByIDUnscoped(id uint) (*User, error)
+	SuspendUser(id uint) error
+	ReactivateUser(id uint) (bool, error)
+	RestoreUser(id uint) (bool, error)
+	RequirePasswordReset(id uint) error
+	PurgeUser(id uint) error
+	// WithTx returns the repository working within the transaction tx
+	WithTx(tx *gorm.DB) UserRepository
+}
+
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDeleted   = "deleted"
+)
+
+type UserFilter struct {
+	Search string // matched against name and email
+	Status string // active, suspended, deleted; empty for all but deleted
+	Role   string
+	Page   int
+	Limit  int
+}
+
+type UsersResponse struct {
+	Users      []User `json:"users"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	TotalPages int    `json:"total_pages"`
+}
+
+// IsSuspended reports whether an admin has suspended the account
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
 }
 
 type userRepository struct {
@@ -31,13 +107,31 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db: db}
 }
 
+func (r *userRepository) WithTx(tx *gorm.DB) UserRepository {
+	return &userRepository{db: tx}
+}
+
+// CreateUser stores the user with the email in the form returned by
+// utils.NormalizeEmail, under which all lookups are made, so the unique index
+// on users.email also covers addresses differing only in case, Unicode form
+// or domain encoding. A taken address yields ErrEmailTaken.
 func (r *userRepository) CreateUser(user *User) error {
-	return r.db.Create(user).Error
+	user.Email = utils.NormalizeEmail(user.Email)
+	return translateEmailError(r.db.Create(user).Error)
+}
+
+// translateEmailError reports a unique violation on insert or update of a
+// user as ErrEmailTaken
+func translateEmailError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrEmailTaken
+	}
+	return err
 }
 
 func (r *userRepository) GetUserByEmail(email string) (*User, error) {
 	var user User
-	err := r.db.Where("email = ?", email).First(&user).Error
+	err := r.db.Where("email = ?", utils.NormalizeEmail(email)).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -51,4 +145,334 @@ func (r *userRepository) GetUserByID(id uint) (*User, error) {
 		return nil, err
 	}
 	return &user, nil
-}
\ No newline at end of file
+}
+
+// IsEmailTaken reports whether the email belongs to any account, including
+// soft-deleted ones that may still be restored
+func (r *userRepository) IsEmailTaken(email string) (bool, error) {
+	var count int64
+	err := r.db.Unscoped().Model(&User{}).Where("email = ?", utils.NormalizeEmail(email)).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// UpdateProfile changes the fields that are set in one write: the name and
+// an email change awaiting confirmation
+func (r *userRepository) UpdateProfile(id uint, name, pendingEmail *string) error {
+	updates := map[string]interface{}{}
+	if name != nil {
+		updates["name"] = *name
+	}
+	if pendingEmail != nil {
+		updates["pending_email"] = utils.NormalizeEmail(*pendingEmail)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return r.db.Model(&User{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// ConfirmEmailChange makes the pending email the user's address and signs
+// the user out everywhere, since their tokens carry the previous address. It
+// reports false when the pending email has changed in the meantime.
+func (r *userRepository) ConfirmEmailChange(id uint, email string) (bool, error) {
+	email = utils.NormalizeEmail(email)
+	changed := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).
+			Where("id = ? AND pending_email = ?", id, email).
+			Updates(map[string]interface{}{
+				"email":             email,
+				"pending_email":     nil,
+				"email_verified_at": time.Now(),
+			})
+		if result.Error != nil {
+			return translateEmailError(result.Error)
+		}
+		if result.RowsAffected != 1 {
+			return nil
+		}
+		changed = true
+		return revokeAllTokens(tx, id)
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+// SoftDeleteUser soft-deletes the user together with their tasks and signs
+// them out everywhere
+func (r *userRepository) SoftDeleteUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := revokeAllTokens(tx, id); err != nil {
+			return err
+		}
+		// Tasks share the user's deletion time so that RestoreUser can tell
+		// them apart from tasks the user had deleted before
+		now := time.Now()
+		if err := tx.Model(&Task{}).Where("user_id = ?", id).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", id).Update("deleted_at", now).Error
+	})
+}
+
+// UpdatePassword stores a new password hash and invalidates every token
+// issued before the change.
+func (r *userRepository) UpdatePassword(id uint, hashedPassword string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"password":                hashedPassword,
+			"password_reset_required": false,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return revokeAllTokens(tx, id)
+	})
+}
+
+// RehashPassword replaces the hash of an unchanged password, e.g. with one
+// made by a stronger algorithm. Unlike UpdatePassword it keeps the user's
+// tokens, and it does nothing if the password changed in the meantime.
+func (r *userRepository) RehashPassword(id uint, oldHash, newHash string) error {
+	return r.db.Model(&User{}).
+		Where("id = ? AND password = ?", id, oldHash).
+		Update("password", newHash).Error
+}
+
+// RevokeAllTokens bumps the user's token version and revokes every refresh
+// token, signing the user out everywhere.
+func (r *userRepository) RevokeAllTokens(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return revokeAllTokens(tx, id)
+	})
+}
+
+func (r *userRepository) MarkEmailVerified(id uint) error {
+	return r.db.Model(&User{}).
+		Where("id = ? AND email_verified_at IS NULL", id).
+		Update("email_verified_at", time.Now()).Error
+}
+
+// SetTOTPSecret stores a pending TOTP secret; it only takes effect once
+// EnableTOTP confirms the enrollment
+func (r *userRepository) SetTOTPSecret(id uint, secret string) error {
+	return r.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	}).Error
+}
+
+// EnableTOTP activates the pending TOTP secret and replaces the user's
+// recovery codes
+func (r *userRepository) EnableTOTP(id uint, recoveryCodeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("totp_enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, id, recoveryCodeHashes)
+	})
+}
+
+func (r *userRepository) DisableTOTP(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code. It reports
+// false when a code of the same or a later step was already accepted, which
+// prevents a code from being replayed.
+func (r *userRepository) UseTOTPStep(id uint, step int64) (bool, error) {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// SetRole changes the role of a user. Existing tokens carry the old role in
+// their claims, so they are revoked.
+func (r *userRepository) SetRole(id uint, role string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("role", role).Error; err != nil {
+			return err
+		}
+		return revokeAllTokens(tx, id)
+	})
+}
+
+// ListUsers searches all users, soft-deleted ones only when filtering by the
+// deleted status
+func (r *userRepository) ListUsers(filter UserFilter) (*UsersResponse, error) {
+	query := r.db.Model(&User{})
+
+	switch filter.Status {
+	case UserStatusActive:
+		query = query.Where("suspended_at IS NULL")
+	case UserStatusSuspended:
+		query = query.Where("suspended_at IS NOT NULL")
+	case UserStatusDeleted:
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Search != "" {
+		pattern := "%" + escapeLike(filter.Search) + "%"
+		query = query.Where("name ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 10
+	}
+	if filter.Limit > 100 {
+		filter.Limit = 100
+	}
+
+	var users []User
+	err := query.Order("id ASC").
+		Limit(filter.Limit).
+		Offset((filter.Page - 1) * filter.Limit).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totalPages := int(total) / filter.Limit
+	if int(total)%filter.Limit > 0 {
+		totalPages++
+	}
+
+	return &UsersResponse{
+		Users:      users,
+		Total:      total,
+		Page:       filter.Page,
+		Limit:      filter.Limit,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// GetUserByIDUnscoped finds a user even if the account was soft-deleted
+func (r *userRepository) GetUserByIDUnscoped(id uint) (*User, error) {
+	var user User
+	err := r.db.Unscoped().First(&user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SuspendUser blocks the user from logging in and signs them out everywhere
+func (r *userRepository) SuspendUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).
+			Where("id = ? AND suspended_at IS NULL", id).
+			Update("suspended_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return revokeAllTokens(tx, id)
+	})
+}
+
+// ReactivateUser undoes SuspendUser. It reports false when the user is not
+// suspended.
+func (r *userRepository) ReactivateUser(id uint) (bool, error) {
+	result := r.db.Model(&User{}).Where("id = ? AND suspended_at IS NOT NULL", id).Update("suspended_at", nil)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RestoreUser undoes SoftDeleteUser, bringing back the tasks deleted along
+// with the account. It reports false when the user is not deleted.
+func (r *userRepository) RestoreUser(id uint) (bool, error) {
+	restored := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&user).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Model(&Task{}).
+			Where("user_id = ? AND deleted_at = ?", id, user.DeletedAt.Time).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&User{}).Where("id = ?", id).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		restored = true
+		return nil
+	})
+	return restored, err
+}
+
+// RequirePasswordReset blocks password logins until the user resets their
+// password, and signs them out everywhere
+func (r *userRepository) RequirePasswordReset(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("password_reset_required", true).Error; err != nil {
+			return err
+		}
+		return revokeAllTokens(tx, id)
+	})
+}
+
+// PurgeUser permanently deletes the user and all their tasks. Tokens,
+// sessions and identities go with the user through ON DELETE CASCADE.
+func (r *userRepository) PurgeUser(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(&Task{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&User{}, id).Error
+	})
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func revokeAllTokens(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&User{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return revokeSessionsWhere(tx, "user_id = ?", userID)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/hrusfandi/sb-task-management/utils"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's stable subject identifier
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Provider  string    `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject   string    `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject" json:"-"`
+	Email     string    `gorm:"not null" json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type UserIdentityRepository interface {
+	GetUserIdentity(provider, subject string) (*UserIdentity, error)
+	CreateUserIdentity(identity *UserIdentity) error
+	CreateUserWithIdentity(user *User, identity *UserIdentity) error
+	UpdateUserIdentityEmail(id uint, email string) error
+}
+
+type userIdentityRepository struct {
+	db *gorm.DB
+}
+
+func NewUserIdentityRepository(db *gorm.DB) UserIdentityRepository {
+	return &userIdentityRepository{db: db}
+}
+
+func (r *userIdentityRepository) GetUserIdentity(provider, subject string) (*UserIdentity, error) {
+	var identity UserIdentity
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *userIdentityRepository) CreateUserIdentity(identity *UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+// CreateUserWithIdentity provisions a new user together with its first
+// linked identity
+func (r *userIdentityRepository) CreateUserWithIdentity(user *User, identity *UserIdentity) error {
+	user.Email = utils.NormalizeEmail(user.Email)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return translateEmailError(err)
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *userIdentityRepository) UpdateUserIdentityEmail(id uint, email string) error {
+	return r.db.Model(&UserIdentity{}).Where("id = ?", id).Update("email", email).Error
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	UserTokenPurposePasswordReset     = "password_reset"
+	UserTokenPurposeEmailVerification = "email_verification"
+	UserTokenPurposeEmailChange       = "email_change"
+	UserTokenPurposeMagicLink         = "magic_link"
+)
+
+// UserToken is a hashed, expiring, single-use token emailed to a user, such
+// as a password reset link. Purpose scopes a token to a single flow.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"not null" json:"purpose"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type UserTokenRepository interface {
+	CreateUserToken(token *UserToken) error
+	GetValidUserToken(tokenHash, purpose string) (*UserToken, error)
+	ConsumeUserToken(tokenHash, purpose string) (*UserToken, error)
+	InvalidateUserTokens(userID uint, purpose string) error
+	GetLatestUserToken(userID uint, purpose string) (*UserToken, error)
+	// WithTx returns the repository working within the transaction tx
+	WithTx(tx *gorm.DB) UserTokenRepository
+}
+
+type userTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) UserTokenRepository {
+	return &userTokenRepository{db: db}
+}
+
+func (r *userTokenRepository) WithTx(tx *gorm.DB) UserTokenRepository {
+	return &userTokenRepository{db: tx}
+}
+
+func (r *userTokenRepository) CreateUserToken(token *UserToken) error {
+	return r.db.Create(token).Error
+}
+
+// GetValidUserToken returns an unused, unexpired token without consuming it
+func (r *userTokenRepository) GetValidUserToken(tokenHash, purpose string) (*UserToken, error) {
+	var token UserToken
+	err := r.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, time.Now()).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeUserToken atomically marks a valid token as used and returns it.
+// Unknown, expired and already used tokens yield gorm.ErrRecordNotFound.
+func (r *userTokenRepository) ConsumeUserToken(tokenHash, purpose string) (*UserToken, error) {
+	var tokens []UserToken
+	result := r.db.Model(&tokens).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(tokens) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &tokens[0], nil
+}
+
+// InvalidateUserTokens marks every outstanding token of the purpose as used
+func (r *userTokenRepository) InvalidateUserTokens(userID uint, purpose string) error {
+	return r.db.Model(&UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+func (r *userTokenRepository) GetLatestUserToken(userID uint, purpose string) (*UserToken, error) {
+	var token UserToken
+	err := r.db.Where("user_id = ? AND purpose = ?", userID, purpose).
+		Order("created_at DESC").
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
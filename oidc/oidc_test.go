@@ -0,0 +1,307 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "task-api"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:8080/api/oidc/corp/callback"
+)
+
+// mockProvider is a minimal OpenID provider that issues RS256 ID tokens
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+
+	// overrides applied to the next issued ID token
+	audience string
+	nonce    string
+	expires  time.Duration
+}
+
+type authorization struct {
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	p := &mockProvider{key: key, codes: make(map[string]authorization), expires: time.Hour}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.handleToken)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize simulates the user approving the login at the provider and
+// returns the code that would be sent to the redirect URL
+func (p *mockProvider) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid auth URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth URL does not use PKCE S256: %s", authURL)
+	}
+
+	code = "code-" + q.Get("state")
+	p.mu.Lock()
+	p.codes[code] = authorization{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (p *mockProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != testClientID || clientSecret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !ok || CodeChallengeS256(r.PostForm.Get("code_verifier")) != auth.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	audience, nonce := testClientID, auth.nonce
+	if p.audience != "" {
+		audience = p.audience
+	}
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+
+	claims := IDTokenClaims{
+		Nonce:         nonce,
+		Email:         "jane@corp.example",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.server.URL,
+			Subject:   "user-123",
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(p.expires)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock-key"
+	idToken, _ := token.SignedString(p.key)
+
+	json.NewEncoder(w).Encode(TokenResponse{AccessToken: "at", TokenType: "Bearer", IDToken: idToken, ExpiresIn: 3600})
+}
+
+func (p *mockProvider) client() *Client {
+	return NewClient(Config{
+		Issuer:       p.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, p.server.Client())
+}
+
+// login runs the full authorization code flow and returns the verified claims
+func (p *mockProvider) login(t *testing.T, c *Client) (*IDTokenClaims, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	state, _ := GenerateRandomString()
+	nonce, _ := GenerateRandomString()
+	verifier, _ := GenerateRandomString()
+
+	authURL, err := c.AuthCodeURL(ctx, state, nonce, CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	code, returnedState := p.authorize(t, authURL)
+	if returnedState != state {
+		t.Fatalf("state = %v, want %v", returnedState, state)
+	}
+
+	token, err := c.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	return c.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p := newMockProvider(t)
+
+	claims, err := p.login(t, p.client())
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+
+	if claims.Subject != "user-123" {
+		t.Errorf("subject = %v, want user-123", claims.Subject)
+	}
+	if claims.Email != "jane@corp.example" || !claims.EmailVerified {
+		t.Errorf("email = %v (verified %v), want verified jane@corp.example", claims.Email, claims.EmailVerified)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newMockProvider(t)
+
+	authURL, err := p.client().AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-challenge")
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	if !strings.HasPrefix(authURL, p.server.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL() = %v, want the authorization endpoint", authURL)
+	}
+	u, _ := url.Parse(authURL)
+	want := map[string]string{
+		"response_type":  "code",
+		"client_id":      testClientID,
+		"redirect_uri":   testRedirectURL,
+		"scope":          "openid email profile",
+		"state":          "the-state",
+		"nonce":          "the-nonce",
+		"code_challenge": "the-challenge",
+	}
+	for key, value := range want {
+		if got := u.Query().Get(key); got != value {
+			t.Errorf("AuthCodeURL() %s = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	p := newMockProvider(t)
+	c := p.client()
+	ctx := context.Background()
+
+	verifier, _ := GenerateRandomString()
+	authURL, _ := c.AuthCodeURL(ctx, "state", "nonce", CodeChallengeS256(verifier))
+	code, _ := p.authorize(t, authURL)
+
+	if _, err := c.Exchange(ctx, code, "not-the-verifier"); err == nil {
+		t.Error("Exchange() accepted a wrong PKCE verifier")
+	}
+}
+
+func TestVerifyIDTokenRejections(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *mockProvider)
+	}{
+		{"Wrong audience", func(p *mockProvider) { p.audience = "another-client" }},
+		{"Nonce mismatch", func(p *mockProvider) { p.nonce = "replayed-nonce" }},
+		{"Expired token", func(p *mockProvider) { p.expires = -time.Hour }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t)
+			tt.setup(p)
+
+			if _, err := p.login(t, p.client()); err == nil {
+				t.Error("VerifyIDToken() accepted an invalid ID token")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsForeignKey(t *testing.T) {
+	p := newMockProvider(t)
+	c := p.client()
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, IDTokenClaims{
+		Nonce: "nonce",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.server.URL,
+			Subject:   "user-123",
+			Audience:  jwt.ClaimStrings{testClientID},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	token.Header["kid"] = "mock-key"
+	forged, _ := token.SignedString(otherKey)
+
+	if _, err := c.VerifyIDToken(context.Background(), forged, "nonce"); err == nil {
+		t.Error("VerifyIDToken() accepted a token signed by an unknown key")
+	}
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	p := newMockProvider(t)
+
+	// The trailing slash resolves to the same discovery document, whose issuer
+	// then differs from the configured one
+	c := NewClient(Config{Issuer: p.server.URL + "/", ClientID: testClientID}, p.server.Client())
+	if _, err := c.Discover(context.Background()); err == nil {
+		t.Error("Discover() accepted a document for another issuer")
+	}
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// RFC 7636 Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := CodeChallengeS256(verifier); got != want {
+		t.Errorf("CodeChallengeS256() = %v, want %v", got, want)
+	}
+}
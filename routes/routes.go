@@ -4,13 +4,17 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/hrusfandi/sb-task-management/config"
 	"github.com/hrusfandi/sb-task-management/handlers"
+	"github.com/hrusfandi/sb-task-management/mailer"
 	authMiddleware "github.com/hrusfandi/sb-task-management/middleware"
 	"github.com/hrusfandi/sb-task-management/models"
+	"github.com/hrusfandi/sb-task-management/oidc"
+	"github.com/hrusfandi/sb-task-management/utils"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(db *gorm.DB) *chi.Mux {
+func SetupRoutes(db *gorm.DB, keyring *utils.Keyring, mail mailer.Mailer) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
@@ -28,24 +32,141 @@ func SetupRoutes(db *gorm.DB) *chi.Mux {
 	}))
 
 	userRepo := models.NewUserRepository(db)
-	authHandler := handlers.NewAuthHandler(userRepo)
+	refreshTokenRepo := models.NewRefreshTokenRepository(db)
+	revokedTokenRepo := models.NewCachedRevokedTokenRepository(models.NewRevokedTokenRepository(db))
+	userTokenRepo := models.NewUserTokenRepository(db)
+	recoveryCodeRepo := models.NewRecoveryCodeRepository(db)
+	sessionRepo := models.NewSessionRepository(db)
+	loginAttemptRepo := models.NewLoginAttemptRepository(db)
+	auditRepo := models.NewAuditEventRepository(db)
+	authHandler := handlers.NewAuthHandler(userRepo, refreshTokenRepo, revokedTokenRepo, userTokenRepo, recoveryCodeRepo, sessionRepo, loginAttemptRepo, auditRepo, mail)
+	patRepo := models.NewPersonalAccessTokenRepository(db)
+	patHandler := handlers.NewPersonalAccessTokenHandler(patRepo, auditRepo)
+	authenticator := authMiddleware.NewAuthenticator(userRepo, revokedTokenRepo, patRepo, sessionRepo)
+
+	oidcProviders := make(map[string]*oidc.Client)
+	for _, provider := range config.AppConfig.OIDCProviders {
+		oidcProviders[provider.Name] = oidc.NewClient(oidc.Config{
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		}, nil)
+	}
+	oidcHandler := handlers.NewOIDCHandler(authHandler, models.NewUserIdentityRepository(db), oidcProviders)
 
 	taskRepo := models.NewTaskRepository(db)
-	taskHandler := handlers.NewTaskHandler(taskRepo)
+	labelRepo := models.NewLabelRepository(db)
+	taskHandler := handlers.NewTaskHandler(taskRepo, labelRepo, auditRepo)
+	labelHandler := handlers.NewLabelHandler(labelRepo)
+	adminHandler := handlers.NewAdminHandler(authHandler, userRepo, taskRepo, auditRepo)
+
+	jwksHandler := handlers.NewJWKSHandler(keyring)
+	r.Get("/.well-known/jwks.json", jwksHandler.GetJWKS)
 
 	r.Route("/api", func(r chi.Router) {
 		r.Post("/register", authHandler.Register)
 		r.Post("/login", authHandler.Login)
+		r.Post("/login/mfa", authHandler.LoginMFA)
+		r.Post("/login/magic-link", authHandler.RequestMagicLink)
+		r.Post("/login/magic-link/verify", authHandler.VerifyMagicLink)
+		r.Post("/token/refresh", authHandler.RefreshToken)
+		r.Post("/password/forgot", authHandler.ForgotPassword)
+		r.Post("/password/reset", authHandler.ResetPassword)
+		r.Get("/verify-email", authHandler.VerifyEmail)
+		r.Post("/verify-email/resend", authHandler.ResendVerification)
+		r.Get("/confirm-email-change", authHandler.ConfirmEmailChange)
+		r.Get("/oidc/{provider}/login", oidcHandler.Login)
+		r.Get("/oidc/{provider}/callback", oidcHandler.Callback)
 
 		r.Group(func(r chi.Router) {
-			r.Use(authMiddleware.JWTAuth)
+			r.Use(authenticator.JWTAuth)
+
+			// Account management requires an interactive login
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.DenyPersonalAccessTokens)
+
+				// Admins impersonating a user cannot change their credentials
+				sensitive := authMiddleware.DenyImpersonation
+
+				r.Post("/logout", authHandler.Logout)
+				r.With(sensitive).Post("/logout-all", authHandler.LogoutAll)
+
+				r.Get("/me", authHandler.GetProfile)
+				r.With(sensitive).Put("/me", authHandler.UpdateProfile)
+				r.With(sensitive).Delete("/me", authHandler.DeleteAccount)
+				r.With(sensitive).Post("/me/password", authHandler.ChangePassword)
+				r.Get("/me/sessions", authHandler.ListSessions)
+				r.With(sensitive).Delete("/me/sessions/{id}", authHandler.RevokeSession)
+
+				r.Route("/me/mfa", func(r chi.Router) {
+					r.Use(sensitive)
+
+					r.Post("/totp", authHandler.EnrollTOTP)
+					r.Post("/totp/confirm", authHandler.ConfirmTOTP)
+					r.Delete("/totp", authHandler.DisableTOTP)
+					r.Post("/recovery-codes", authHandler.RegenerateRecoveryCodes)
+				})
+
+				r.Route("/me/tokens", func(r chi.Router) {
+					r.With(sensitive).Post("/", patHandler.CreateToken)
+					r.Get("/", patHandler.ListTokens)
+					r.With(sensitive).Delete("/{id}", patHandler.RevokeToken)
+				})
+
+				r.Route("/admin", func(r chi.Router) {
+					r.Use(authMiddleware.RequireRole(models.RoleAdmin))
+
+					r.Get("/users", adminHandler.ListUsers)
+					r.Get("/users/{id}", adminHandler.GetUser)
+					r.Delete("/users/{id}", adminHandler.PurgeUser)
+					r.Post("/users/{id}/suspend", adminHandler.SuspendUser)
+					r.Post("/users/{id}/reactivate", adminHandler.ReactivateUser)
+					r.Post("/users/{id}/restore", adminHandler.RestoreUser)
+					r.Post("/users/{id}/password-reset", adminHandler.ForcePasswordReset)
+					r.Post("/users/{id}/impersonate", adminHandler.ImpersonateUser)
+					r.Get("/users/{id}/tasks", adminHandler.ListUserTasks)
+					r.Get("/tasks/{id}", adminHandler.GetTask)
+					r.Get("/audit-events", adminHandler.ListAuditEvents)
+				})
+			})
 
 			r.Route("/tasks", func(r chi.Router) {
-				r.Post("/", taskHandler.CreateTask)
-				r.Get("/", taskHandler.ListTasks)
-				r.Get("/{id}", taskHandler.GetTask)
-				r.Put("/{id}", taskHandler.UpdateTask)
-				r.Delete("/{id}", taskHandler.DeleteTask)
+				if config.AppConfig.EmailVerificationMode == config.EmailVerificationTasks {
+					r.Use(authMiddleware.RequireVerifiedEmail)
+				}
+
+				readTasks := authMiddleware.RequireScope(models.ScopeTasksRead)
+				writeTasks := authMiddleware.RequireScope(models.ScopeTasksWrite)
+
+				r.With(writeTasks).Post("/", taskHandler.CreateTask)
+				r.With(readTasks).Get("/", taskHandler.ListTasks)
+				r.With(readTasks).Get("/{id}", taskHandler.GetTask)
+				r.With(readTasks).Get("/{id}/subtasks", taskHandler.ListSubtasks)
+				r.With(readTasks).Get("/{id}/tree", taskHandler.GetTaskTree)
+				r.With(readTasks).Get("/{id}/dependencies", taskHandler.ListDependencies)
+				r.With(writeTasks).Post("/{id}/blocked-by", taskHandler.AddBlockedBy)
+				r.With(writeTasks).Delete("/{id}/blocked-by/{otherID}", taskHandler.RemoveBlockedBy)
+				r.With(writeTasks).Post("/{id}/blocks", taskHandler.AddBlocks)
+				r.With(writeTasks).Delete("/{id}/blocks/{otherID}", taskHandler.RemoveBlocks)
+				r.With(writeTasks).Put("/{id}", taskHandler.UpdateTask)
+				r.With(writeTasks).Delete("/{id}", taskHandler.DeleteTask)
+			})
+
+			r.Route("/labels", func(r chi.Router) {
+				if config.AppConfig.EmailVerificationMode == config.EmailVerificationTasks {
+					r.Use(authMiddleware.RequireVerifiedEmail)
+				}
+
+				readTasks := authMiddleware.RequireScope(models.ScopeTasksRead)
+				writeTasks := authMiddleware.RequireScope(models.ScopeTasksWrite)
+
+				r.With(writeTasks).Post("/", labelHandler.CreateLabel)
+				r.With(readTasks).Get("/", labelHandler.ListLabels)
+				r.With(readTasks).Get("/{id}", labelHandler.GetLabel)
+				r.With(writeTasks).Put("/{id}", labelHandler.UpdateLabel)
+				r.With(writeTasks).Delete("/{id}", labelHandler.DeleteLabel)
 			})
 		})
 	})
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+	authMiddleware "github.com/hrusfandi/sb-task-management/middleware"
+	"github.com/hrusfandi/sb-task-management/utils"
+)
+
+func TestSensitiveRoutesDenyImpersonation(t *testing.T) {
+	config.AppConfig = &config.Config{JWTSecret: "test-secret-key-for-testing"}
+	router := SetupRoutes(nil, utils.NewHMACKeyring(config.AppConfig.JWTSecret), nil)
+
+	sensitive := map[string]bool{
+		"POST /api/logout-all":                   true,
+		"PUT /api/me":                            true,
+		"DELETE /api/me":                         true,
+		"POST /api/me/password":                  true,
+		"DELETE /api/me/sessions/{id}":           true,
+		"POST /api/me/mfa/totp":                  true,
+		"POST /api/me/mfa/totp/confirm":          true,
+		"DELETE /api/me/mfa/totp":                true,
+		"POST /api/me/mfa/recovery-codes":        true,
+		"POST /api/me/tokens/":                   true,
+		"DELETE /api/me/tokens/{id}":             true,
+		"GET /api/me":                            false,
+		"GET /api/me/sessions":                   false,
+		"GET /api/me/tokens/":                    false,
+		"POST /api/logout":                       false,
+		"GET /api/tasks/":                        false,
+		"POST /api/admin/users/{id}/impersonate": false,
+	}
+
+	deny := reflect.ValueOf(authMiddleware.DenyImpersonation).Pointer()
+	found := map[string]bool{}
+	err := chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		key := method + " " + route
+		want, listed := sensitive[key]
+		if !listed {
+			return nil
+		}
+		found[key] = true
+
+		denied := false
+		for _, middleware := range middlewares {
+			if reflect.ValueOf(middleware).Pointer() == deny {
+				denied = true
+			}
+		}
+		if denied != want {
+			t.Errorf("%s denies impersonation = %v, want %v", key, denied, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	for key := range sensitive {
+		if !found[key] {
+			t.Errorf("route %s is not registered", key)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// GenesisHash is the previous hash of the first entry of a hash chain
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// ChainHash returns the SHA-256 hex digest linking an entry made of fields to
+// the hash of the entry before it. Every field is length-prefixed so that
+// moving characters between fields changes the hash.
+func ChainHash(prevHash string, fields ...string) string {
+	h := sha256.New()
+	for _, field := range append([]string{prevHash}, fields...) {
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
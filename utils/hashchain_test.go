@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestChainHash(t *testing.T) {
+	base := ChainHash(GenesisHash, "login", "42")
+
+	if len(base) != 64 {
+		t.Errorf("ChainHash() length = %d, want 64", len(base))
+	}
+	if again := ChainHash(GenesisHash, "login", "42"); again != base {
+		t.Errorf("ChainHash() = %v, want the same hash for the same input %v", again, base)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"Different previous hash", ChainHash(base, "login", "42")},
+		{"Different field", ChainHash(GenesisHash, "login", "43")},
+		{"Characters moved between fields", ChainHash(GenesisHash, "login4", "2")},
+		{"Fields joined", ChainHash(GenesisHash, "login42")},
+		{"Extra empty field", ChainHash(GenesisHash, "login", "42", "")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.hash == base {
+				t.Errorf("ChainHash() = %v, want a different hash", tt.hash)
+			}
+		})
+	}
+}
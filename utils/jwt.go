@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -8,25 +10,70 @@ import (
 	"github.com/hrusfandi/sb-task-management/config"
 )
 
+// defaultAccessTokenTTL is used when the configuration does not set one.
+const defaultAccessTokenTTL = 15 * time.Minute
+
+// TokenPurposeMFA marks the short-lived token handed out by the first login
+// step when the second factor is still missing. Such tokens must never be
+// accepted as access tokens.
+const TokenPurposeMFA = "mfa"
+
 type JWTClaims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID       uint   `json:"user_id"`
+	Email        string `json:"email"`
+	Role         string `json:"role,omitempty"`
+	TokenVersion int    `json:"tv"`
+	SessionID    uint   `json:"sid,omitempty"`
+	Purpose      string `json:"purpose,omitempty"`
+	// ImpersonatorID is the admin acting as the user on an impersonation
+	// token, and ImpersonatorTokenVersion the admin's token version at issue
+	ImpersonatorID           uint `json:"imp,omitempty"`
+	ImpersonatorTokenVersion int  `json:"imp_tv,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// IsImpersonation reports whether the token was issued to an admin acting
+// as the user
+func (c *JWTClaims) IsImpersonation() bool {
+	return c.ImpersonatorID != 0
+}
+
+// GenerateToken issues a plain access token for the user, signed with the
+// current key of the keyring
 func GenerateToken(userID uint, email string) (string, error) {
-	claims := JWTClaims{
-		UserID: userID,
-		Email:  email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(config.AppConfig.JWTSecret))
+	return IssueToken(JWTClaims{UserID: userID, Email: email}, AccessTokenTTL())
+}
+
+// IssueToken signs the given claims after assigning a unique token ID (jti),
+// unless the caller already chose one, and the issued-at, not-before and
+// expiry times
+func IssueToken(claims JWTClaims, ttl time.Duration) (string, error) {
+	if claims.ID == "" {
+		jti, err := GenerateTokenID()
+		if err != nil {
+			return "", err
+		}
+		claims.ID = jti
+	}
+
+	now := time.Now()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+
+	return SignClaims(claims)
+}
+
+// SignClaims signs arbitrary claims with the current signing key. Callers
+// signing anything other than JWTClaims must set a purpose claim so that the
+// result can never pass as an access token.
+func SignClaims(claims jwt.Claims) (string, error) {
+	key := currentKeyring().SigningKey()
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	tokenString, err := token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
@@ -34,25 +81,58 @@ func GenerateToken(userID uint, email string) (string, error) {
 	return tokenString, nil
 }
 
+// AccessTokenTTL returns the configured lifetime of access tokens
+func AccessTokenTTL() time.Duration {
+	if config.AppConfig != nil && config.AppConfig.AccessTokenTTL > 0 {
+		return config.AppConfig.AccessTokenTTL
+	}
+	return defaultAccessTokenTTL
+}
+
+// GenerateTokenID returns a random token ID (jti)
+func GenerateTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func ValidateToken(tokenString string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	claims := &JWTClaims{}
+	if err := ParseClaims(tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseClaims verifies a token signed by SignClaims and decodes it into claims
+func ParseClaims(tokenString string, claims jwt.Claims) error {
+	keyring := currentKeyring()
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != keyring.Algorithm() {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(config.AppConfig.JWTSecret), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keyring.VerificationKey(kid)
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{keyring.Algorithm()}))
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-		return claims, nil
+	if !token.Valid {
+		return errors.New("invalid token")
 	}
 
-	return nil, errors.New("invalid token")
+	return nil
 }
 
+// ExtractUserID validates the access token and returns its user ID
 func ExtractUserID(tokenString string) (uint, error) {
 	claims, err := ValidateToken(tokenString)
 	if err != nil {
@@ -12,7 +12,8 @@ import (
 func setupJWTTest() {
 	// Set up test configuration
 	config.AppConfig = &config.Config{
-		JWTSecret: "test-secret-key-for-testing",
+		JWTSecret:      "test-secret-key-for-testing",
+		AccessTokenTTL: 15 * time.Minute,
 	}
 }
 
@@ -194,8 +195,8 @@ func TestJWTClaimsExpiration(t *testing.T) {
 		t.Fatalf("Failed to validate token: %v", err)
 	}
 
-	// Check if expiration is set correctly (should be 24 hours from now)
-	expectedExpiry := time.Now().Add(24 * time.Hour)
+	// Check if expiration is set correctly (should be the configured access token TTL)
+	expectedExpiry := time.Now().Add(config.AppConfig.AccessTokenTTL)
 	actualExpiry := claims.ExpiresAt.Time
 
 	// Allow 1 minute difference for test execution time
@@ -214,4 +215,37 @@ func TestMain(m *testing.M) {
 
 	// Exit
 	os.Exit(code)
-}
\ No newline at end of file
+}
+func TestIssueTokenAssignsUniqueID(t *testing.T) {
+	setupJWTTest()
+
+	claims := JWTClaims{UserID: 7, Email: "test@example.com", TokenVersion: 3}
+
+	token1, err := IssueToken(claims, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	token2, err := IssueToken(claims, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	parsed1, err := ValidateToken(token1)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	parsed2, err := ValidateToken(token2)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	if parsed1.ID == "" {
+		t.Error("IssueToken() did not set a token ID")
+	}
+	if parsed1.ID == parsed2.ID {
+		t.Error("IssueToken() reused the same token ID")
+	}
+	if parsed1.TokenVersion != 3 {
+		t.Errorf("IssueToken() token version = %v, want 3", parsed1.TokenVersion)
+	}
+}
@@ -0,0 +1,500 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// KeyringReloadInterval limits how often an unknown kid triggers a reload of
+// the key directory, which is how keys rotated by other instances are found.
+// Keys whose file exists are loaded right away.
+const KeyringReloadInterval = 10 * time.Second
+
+// SigningKey is a single key of the keyring, identified by its kid
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	private   interface{}
+	public    interface{}
+}
+
+// JWK is the public part of a signing key as published in the JWKS document
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// Keyring holds the keys used to sign and verify tokens. The newest key signs
+// new tokens; older keys keep verifying until every token they signed has
+// expired.
+type Keyring struct {
+	mu         sync.RWMutex
+	algorithm  string
+	dir        string
+	retention  time.Duration
+	keys       []*SigningKey
+	lastReload time.Time
+
+	// reloadMu lets one request at a time reload for an unknown kid; loaded
+	// lists the kids whose file has already been loaded for one
+	reloadMu sync.Mutex
+	loaded   map[string]bool
+}
+
+var (
+	keyringMu     sync.RWMutex
+	activeKeyring *Keyring
+)
+
+// NewKeyring creates a keyring for the given algorithm. Asymmetric keys are
+// loaded from dir (as PKCS#8 PEM files named <kid>.pem) and a first key is
+// generated when none exist. An empty dir keeps keys in memory only.
+// Superseded keys are kept for the retention period.
+func NewKeyring(algorithm, dir string, retention time.Duration) (*Keyring, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	k := &Keyring{
+		algorithm: algorithm,
+		dir:       dir,
+		retention: retention,
+		loaded:    make(map[string]bool),
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := k.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(k.keys) == 0 {
+		if _, err := k.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// NewHMACKeyring creates a keyring holding a single shared HS256 secret
+func NewHMACKeyring(secret string) *Keyring {
+	return &Keyring{
+		algorithm: AlgorithmHS256,
+		keys: []*SigningKey{{
+			Algorithm: AlgorithmHS256,
+			private:   []byte(secret),
+			public:    []byte(secret),
+		}},
+	}
+}
+
+// InitKeyring builds the keyring described by the application configuration
+// and makes it the one used by IssueToken and ValidateToken
+func InitKeyring() (*Keyring, error) {
+	cfg := config.AppConfig
+
+	var keyring *Keyring
+	if cfg.JWTSigningAlgorithm == "" || cfg.JWTSigningAlgorithm == AlgorithmHS256 {
+		keyring = NewHMACKeyring(cfg.JWTSecret)
+	} else {
+		// Keep superseded keys around for as long as tokens they signed
+		// can still be valid, plus a little slack for clock skew.
+		retention := longestSignedTTL() + time.Minute
+		var err error
+		keyring, err = NewKeyring(cfg.JWTSigningAlgorithm, cfg.JWTKeysDir, retention)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.JWTKeysDir == "" {
+			log.Println("Warning: JWT_KEYS_DIR is not set, signing keys will not survive a restart")
+		}
+	}
+
+	SetKeyring(keyring)
+	return keyring, nil
+}
+
+// longestSignedTTL returns the longest lifetime of anything signed with the
+// keyring: access, impersonation and MFA challenge tokens and OIDC login
+// states
+func longestSignedTTL() time.Duration {
+	longest := AccessTokenTTL()
+	for _, ttl := range []time.Duration{
+		config.AppConfig.ImpersonationTTL,
+		config.AppConfig.MFAChallengeTTL,
+		config.OIDCStateTTL,
+	} {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// SetKeyring replaces the keyring used to sign and verify tokens
+func SetKeyring(keyring *Keyring) {
+	keyringMu.Lock()
+	activeKeyring = keyring
+	keyringMu.Unlock()
+}
+
+// currentKeyring returns the configured keyring, falling back to the shared
+// HS256 secret when InitKeyring has not been called
+func currentKeyring() *Keyring {
+	keyringMu.RLock()
+	keyring := activeKeyring
+	keyringMu.RUnlock()
+
+	if keyring == nil {
+		return NewHMACKeyring(config.AppConfig.JWTSecret)
+	}
+	return keyring
+}
+
+// Algorithm returns the signing algorithm of the keyring
+func (k *Keyring) Algorithm() string {
+	return k.algorithm
+}
+
+// SigningKey returns the key that signs new tokens
+func (k *Keyring) SigningKey() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[len(k.keys)-1]
+}
+
+// VerificationKey returns the key with the given kid, if it is still trusted.
+// A keyring backed by a directory reloads it before rejecting an unknown kid,
+// as the key may have just been rotated by another instance.
+func (k *Keyring) VerificationKey(kid string) (*SigningKey, bool) {
+	if key, ok := k.lookup(kid); ok {
+		return key, true
+	}
+	if k.dir == "" {
+		return nil, false
+	}
+
+	k.reloadMu.Lock()
+	defer k.reloadMu.Unlock()
+
+	// Another request may have reloaded while this one was waiting
+	if key, ok := k.lookup(kid); ok {
+		return key, true
+	}
+
+	// Each key file is worth one immediate reload; other kids, e.g. made up
+	// ones, only reload once the interval has passed
+	reload := false
+	if k.keyFileExists(kid) && !k.loaded[kid] {
+		k.loaded[kid] = true
+		reload = true
+	} else {
+		k.mu.RLock()
+		reload = time.Since(k.lastReload) > KeyringReloadInterval
+		k.mu.RUnlock()
+	}
+	if !reload {
+		return nil, false
+	}
+
+	if err := k.reload(); err != nil {
+		log.Printf("Failed to reload signing keys: %v", err)
+		return nil, false
+	}
+	return k.lookup(kid)
+}
+
+// keyFileExists reports whether the key directory has a file for the kid
+func (k *Keyring) keyFileExists(kid string) bool {
+	if kid == "" || filepath.Base(kid) != kid || strings.HasPrefix(kid, ".") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(k.dir, kid+".pem"))
+	return err == nil
+}
+
+func (k *Keyring) lookup(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Rotate generates a new signing key, persists it when the keyring is backed
+// by a directory and drops keys whose retention period has passed
+func (k *Keyring) Rotate() (*SigningKey, error) {
+	if k.algorithm == AlgorithmHS256 {
+		return nil, errors.New("HS256 keyrings cannot be rotated")
+	}
+
+	key, err := generateSigningKey(k.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.dir != "" {
+		if err := writeSigningKey(k.dir, key); err != nil {
+			return nil, err
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = append(k.keys, key)
+	retired := k.prune(time.Now())
+	k.mu.Unlock()
+
+	if k.dir != "" {
+		for _, old := range retired {
+			if err := os.Remove(filepath.Join(k.dir, old.ID+".pem")); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove retired signing key %s: %v", old.ID, err)
+			}
+		}
+	}
+
+	return key, nil
+}
+
+// StartRotation rotates the signing key every interval. It blocks, so it is
+// meant to run in its own goroutine.
+func (k *Keyring) StartRotation(interval time.Duration) {
+	for range time.Tick(interval) {
+		key, err := k.Rotate()
+		if err != nil {
+			log.Printf("Failed to rotate signing key: %v", err)
+			continue
+		}
+		log.Printf("Rotated signing key, new kid %s", key.ID)
+	}
+}
+
+// StartReloading reloads the key directory every interval, so that instances
+// which do not rotate keys themselves sign with the newest key and drop
+// retired ones. It blocks, so it is meant to run in its own goroutine.
+func (k *Keyring) StartReloading(interval time.Duration) {
+	if k.dir == "" {
+		return
+	}
+	for range time.Tick(interval) {
+		if err := k.reload(); err != nil {
+			log.Printf("Failed to reload signing keys: %v", err)
+		}
+	}
+}
+
+// PublicKeys returns the JWKs of every key that can still verify tokens
+func (k *Keyring) PublicKeys() []JWK {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := make([]JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
+
+// prune drops keys that were superseded longer than the retention period
+// ago and returns them. The newest key is always kept. Callers must hold the
+// write lock.
+func (k *Keyring) prune(now time.Time) []*SigningKey {
+	var kept, retired []*SigningKey
+	for i, key := range k.keys {
+		if i < len(k.keys)-1 && now.Sub(k.keys[i+1].CreatedAt) > k.retention {
+			retired = append(retired, key)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	k.keys = kept
+	return retired
+}
+
+func (k *Keyring) reload() error {
+	keys, err := readSigningKeys(k.dir, k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastReload = time.Now()
+	if len(keys) == 0 {
+		return nil
+	}
+	k.keys = keys
+	k.prune(time.Now())
+	return nil
+}
+
+func (k *SigningKey) signingMethod() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func generateSigningKey(algorithm string) (*SigningKey, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:        hex.EncodeToString(b),
+		Algorithm: algorithm,
+		CreatedAt: time.Now(),
+	}
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public = private, &private.PublicKey
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public = private, public
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	return key, nil
+}
+
+func writeSigningKey(dir string, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	path := filepath.Join(dir, key.ID+".pem")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chtimes(path, key.CreatedAt, key.CreatedAt)
+}
+
+// readSigningKeys loads every <kid>.pem file of the directory matching the
+// algorithm, ordered from oldest to newest by modification time
+func readSigningKeys(dir, algorithm string) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data found", entry.Name())
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		key := &SigningKey{
+			ID:        strings.TrimSuffix(entry.Name(), ".pem"),
+			CreatedAt: info.ModTime(),
+			private:   private,
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			key.Algorithm, key.public = AlgorithmRS256, &private.PublicKey
+		case ed25519.PrivateKey:
+			key.Algorithm, key.public = AlgorithmEdDSA, private.Public()
+		default:
+			return nil, fmt.Errorf("%s: unsupported key type %T", entry.Name(), private)
+		}
+
+		if key.Algorithm != algorithm {
+			log.Printf("Skipping signing key %s: algorithm %s does not match %s", key.ID, key.Algorithm, algorithm)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hrusfandi/sb-task-management/config"
+)
+
+func TestKeyringSignAndVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		keyType   string
+	}{
+		{name: "RS256", algorithm: AlgorithmRS256, keyType: "RSA"},
+		{name: "EdDSA", algorithm: AlgorithmEdDSA, keyType: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := NewKeyring(tt.algorithm, "", time.Hour)
+			if err != nil {
+				t.Fatalf("NewKeyring() error = %v", err)
+			}
+			SetKeyring(keyring)
+			defer SetKeyring(nil)
+
+			token, err := GenerateToken(1, "test@example.com")
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &JWTClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified() error = %v", err)
+			}
+			if parsed.Header["alg"] != tt.algorithm {
+				t.Errorf("token alg = %v, want %v", parsed.Header["alg"], tt.algorithm)
+			}
+			if parsed.Header["kid"] != keyring.SigningKey().ID {
+				t.Errorf("token kid = %v, want %v", parsed.Header["kid"], keyring.SigningKey().ID)
+			}
+
+			claims, err := ValidateToken(token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if claims.Email != "test@example.com" {
+				t.Errorf("ValidateToken() email = %v, want test@example.com", claims.Email)
+			}
+
+			jwks := keyring.PublicKeys()
+			if len(jwks) != 1 || jwks[0].KeyType != tt.keyType {
+				t.Errorf("PublicKeys() = %+v, want one %s key", jwks, tt.keyType)
+			}
+		})
+	}
+}
+
+func TestKeyringRejectsHMACToken(t *testing.T) {
+	setupJWTTest()
+	hmacToken, _ := GenerateToken(1, "test@example.com")
+
+	keyring, err := NewKeyring(AlgorithmEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	SetKeyring(keyring)
+	defer SetKeyring(nil)
+
+	if _, err := ValidateToken(hmacToken); err == nil {
+		t.Error("ValidateToken() accepted an HS256 token on an EdDSA keyring")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	keyring, err := NewKeyring(AlgorithmEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	SetKeyring(keyring)
+	defer SetKeyring(nil)
+
+	oldToken, _ := GenerateToken(1, "test@example.com")
+	oldKey := keyring.SigningKey()
+
+	newKey, err := keyring.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if keyring.SigningKey() != newKey {
+		t.Error("Rotate() did not make the new key the signing key")
+	}
+
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Errorf("ValidateToken() rejected a token signed by the previous key: %v", err)
+	}
+	if len(keyring.PublicKeys()) != 2 {
+		t.Errorf("PublicKeys() returned %d keys, want 2", len(keyring.PublicKeys()))
+	}
+
+	// Once the retention period has passed the old key is dropped
+	keyring.mu.Lock()
+	newKey.CreatedAt = time.Now().Add(-2 * time.Hour)
+	keyring.prune(time.Now())
+	keyring.mu.Unlock()
+
+	if _, ok := keyring.VerificationKey(oldKey.ID); ok {
+		t.Error("VerificationKey() still returned a retired key")
+	}
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Error("ValidateToken() accepted a token signed by a retired key")
+	}
+}
+
+func TestKeyringPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	keyring, err := NewKeyring(AlgorithmRS256, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	kid := keyring.SigningKey().ID
+
+	if _, err := os.Stat(filepath.Join(dir, kid+".pem")); err != nil {
+		t.Fatalf("signing key was not written to disk: %v", err)
+	}
+
+	reloaded, err := NewKeyring(AlgorithmRS256, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	if reloaded.SigningKey().ID != kid {
+		t.Errorf("reloaded signing key = %v, want %v", reloaded.SigningKey().ID, kid)
+	}
+
+	// Keys rotated by another instance are picked up on an unknown kid, even
+	// right after the last reload
+	rotated, err := keyring.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if _, ok := reloaded.VerificationKey(rotated.ID); !ok {
+		t.Error("VerificationKey() did not find a key rotated by another keyring")
+	}
+}
+
+func TestKeyringReloadRateLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	keyring, err := NewKeyring(AlgorithmEdDSA, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	// A made-up kid does not make the keyring read the directory again
+	keyring.mu.Lock()
+	lastReload := keyring.lastReload
+	keyring.mu.Unlock()
+	for _, kid := range []string{"unknown", "../unknown", ""} {
+		if _, ok := keyring.VerificationKey(kid); ok {
+			t.Errorf("VerificationKey(%q) found a key", kid)
+		}
+	}
+	keyring.mu.Lock()
+	reloadedAt := keyring.lastReload
+	keyring.mu.Unlock()
+	if !reloadedAt.Equal(lastReload) {
+		t.Error("VerificationKey() reloaded the keys for an unknown kid within the reload interval")
+	}
+
+	// Once the interval has passed it does
+	keyring.mu.Lock()
+	keyring.lastReload = time.Now().Add(-2 * KeyringReloadInterval)
+	keyring.mu.Unlock()
+	keyring.VerificationKey("unknown")
+	keyring.mu.Lock()
+	reloadedAt = keyring.lastReload
+	keyring.mu.Unlock()
+	if time.Since(reloadedAt) > KeyringReloadInterval {
+		t.Error("VerificationKey() did not reload the keys after the reload interval")
+	}
+}
+
+func TestNewKeyringUnsupportedAlgorithm(t *testing.T) {
+	if _, err := NewKeyring("ES512", "", time.Hour); err == nil {
+		t.Error("NewKeyring() accepted an unsupported algorithm")
+	}
+}
+
+func TestLongestSignedTTL(t *testing.T) {
+	defer func(cfg *config.Config) { config.AppConfig = cfg }(config.AppConfig)
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want time.Duration
+	}{
+		{
+			name: "access token",
+			cfg:  config.Config{AccessTokenTTL: time.Hour, ImpersonationTTL: 15 * time.Minute, MFAChallengeTTL: 5 * time.Minute},
+			want: time.Hour,
+		},
+		{
+			name: "impersonation token",
+			cfg:  config.Config{AccessTokenTTL: 15 * time.Minute, ImpersonationTTL: 2 * time.Hour, MFAChallengeTTL: 5 * time.Minute},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "MFA challenge",
+			cfg:  config.Config{AccessTokenTTL: 15 * time.Minute, ImpersonationTTL: 15 * time.Minute, MFAChallengeTTL: 30 * time.Minute},
+			want: 30 * time.Minute,
+		},
+		{
+			name: "OIDC state",
+			cfg:  config.Config{AccessTokenTTL: 5 * time.Minute, ImpersonationTTL: 5 * time.Minute, MFAChallengeTTL: 5 * time.Minute},
+			want: config.OIDCStateTTL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			config.AppConfig = &cfg
+			if got := longestSignedTTL(); got != tt.want {
+				t.Errorf("longestSignedTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
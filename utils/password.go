@@ -1,17 +1,234 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hrusfandi/sb-task-management/config"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+
+	// bcrypt only looks at the first 72 bytes of a password
+	bcryptMaxPasswordBytes = 72
+)
+
+var (
+	ErrPasswordMismatch    = errors.New("password does not match")
+	ErrUnknownPasswordHash = errors.New("unrecognized password hash format")
+)
+
+// PasswordHasher hashes passwords with one algorithm
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify compares the password with a hash produced by this algorithm,
+	// using the parameters recorded in the hash
+	Verify(hash, password string) error
+	// Matches reports whether the hash was produced by this algorithm
+	Matches(hash string) bool
+	// NeedsRehash reports whether the hash was made with weaker parameters
+	// than the hasher's
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idHasher produces argon2id hashes in the PHC string format:
+// $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idHasher uses the second recommended option of RFC 9106
+func DefaultArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) error {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		uint32(len(key)) < h.KeyLength
+}
+
+// decodeArgon2idHash parses a PHC string produced by Argon2idHasher.Hash
+func decodeArgon2idHash(hash string) (params Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id key")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// BcryptHasher produces bcrypt hashes. Passwords longer than 72 bytes are
+// refused rather than silently truncated.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
 	if err != nil {
 		return "", err
 	}
 	return string(hashedPassword), nil
 }
 
+func (h *BcryptHasher) Verify(hash, password string) error {
+	// bcrypt would compare only the first 72 bytes, accepting any password
+	// sharing that prefix. No such hash can have been created by Hash.
+	if len(password) > bcryptMaxPasswordBytes {
+		return ErrPasswordMismatch
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+func (h *BcryptHasher) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+var (
+	hasherMu       sync.RWMutex
+	passwordHasher PasswordHasher
+)
+
+// InitPasswordHasher builds the hasher for new passwords from the
+// configuration and installs it
+func InitPasswordHasher() (PasswordHasher, error) {
+	cfg := config.AppConfig
+
+	var hasher PasswordHasher
+	switch cfg.PasswordHashAlgorithm {
+	case PasswordHashArgon2id:
+		argon := DefaultArgon2idHasher()
+		argon.Memory = uint32(cfg.Argon2Memory)
+		argon.Iterations = uint32(cfg.Argon2Iterations)
+		argon.Parallelism = uint8(cfg.Argon2Parallelism)
+		hasher = argon
+	case PasswordHashBcrypt:
+		hasher = &BcryptHasher{Cost: cfg.BcryptCost}
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", cfg.PasswordHashAlgorithm)
+	}
+
+	SetPasswordHasher(hasher)
+	return hasher, nil
+}
+
+// SetPasswordHasher installs the hasher used by HashPassword
+func SetPasswordHasher(hasher PasswordHasher) {
+	hasherMu.Lock()
+	defer hasherMu.Unlock()
+	passwordHasher = hasher
+}
+
+func currentPasswordHasher() PasswordHasher {
+	hasherMu.RLock()
+	hasher := passwordHasher
+	hasherMu.RUnlock()
+
+	if hasher != nil {
+		return hasher
+	}
+	return DefaultArgon2idHasher()
+}
+
+// HashPassword hashes a new password with the installed hasher
+func HashPassword(password string) (string, error) {
+	return currentPasswordHasher().Hash(password)
+}
+
+// ComparePassword checks a password against a stored hash of any supported
+// algorithm
 func ComparePassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-}
\ No newline at end of file
+	current := currentPasswordHasher()
+	for _, hasher := range []PasswordHasher{current, &Argon2idHasher{}, &BcryptHasher{}} {
+		if hasher.Matches(hashedPassword) {
+			return hasher.Verify(hashedPassword, password)
+		}
+	}
+	return ErrUnknownPasswordHash
+}
+
+// PasswordNeedsRehash reports whether a stored hash should be replaced by one
+// from the installed hasher, because it uses another algorithm or weaker
+// parameters
+func PasswordNeedsRehash(hashedPassword string) bool {
+	hasher := currentPasswordHasher()
+	return !hasher.Matches(hashedPassword) || hasher.NeedsRehash(hashedPassword)
+}
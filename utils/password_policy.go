@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/hrusfandi/sb-task-management/config"
+)
+
+// PasswordPolicy describes the requirements new passwords must meet
+type PasswordPolicy struct {
+	// Lengths are measured in characters (runes), not bytes
+	MinLength int
+	MaxLength int
+
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+
+	// MinStrength is the lowest accepted EstimatePasswordStrength score, 0-4
+	MinStrength int
+
+	// RejectPersonalInfo refuses passwords containing the user's name or
+	// email address
+	RejectPersonalInfo bool
+
+	// Breached is consulted when set
+	Breached *BreachedPasswordList
+}
+
+var (
+	policyMu       sync.RWMutex
+	passwordPolicy *PasswordPolicy
+)
+
+// InitPasswordPolicy builds the policy from the configuration and installs it
+func InitPasswordPolicy() (*PasswordPolicy, error) {
+	cfg := config.AppConfig
+	policy := &PasswordPolicy{
+		MinLength:          cfg.PasswordMinLength,
+		MaxLength:          cfg.PasswordMaxLength,
+		RequireUppercase:   cfg.PasswordRequireUppercase,
+		RequireLowercase:   cfg.PasswordRequireLowercase,
+		RequireDigit:       cfg.PasswordRequireDigit,
+		RequireSymbol:      cfg.PasswordRequireSymbol,
+		MinStrength:        cfg.PasswordMinStrength,
+		RejectPersonalInfo: cfg.PasswordRejectPersonalInfo,
+	}
+
+	if cfg.PasswordBreachedListDir != "" {
+		list, err := NewBreachedPasswordList(cfg.PasswordBreachedListDir)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = list
+	}
+
+	SetPasswordPolicy(policy)
+	return policy, nil
+}
+
+// SetPasswordPolicy installs the policy used by CheckPassword
+func SetPasswordPolicy(policy *PasswordPolicy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	passwordPolicy = policy
+}
+
+func currentPasswordPolicy() *PasswordPolicy {
+	policyMu.RLock()
+	policy := passwordPolicy
+	policyMu.RUnlock()
+
+	if policy != nil {
+		return policy
+	}
+	return &PasswordPolicy{MinLength: 6, MaxLength: 100, RejectPersonalInfo: true}
+}
+
+// CheckPassword validates a new password of the user with the given name and
+// email against the installed policy and returns every violation
+func CheckPassword(password, name, email string) []string {
+	violations := currentPasswordPolicy().Check(password, name, email)
+	if _, ok := currentPasswordHasher().(*BcryptHasher); ok && len(password) > bcryptMaxPasswordBytes {
+		violations = append(violations, fmt.Sprintf("Password must not exceed %d bytes", bcryptMaxPasswordBytes))
+	}
+	return violations
+}
+
+// Check returns every requirement the password fails, or nil if it is
+// acceptable
+func (p *PasswordPolicy) Check(password, name, email string) []string {
+	var violations []string
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("Password must not exceed %d characters", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, "Password must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "Password must contain a symbol")
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, name, email) {
+		violations = append(violations, "Password must not contain your name or email address")
+	}
+
+	if p.MinStrength > 0 && EstimatePasswordStrength(password) < p.MinStrength {
+		violations = append(violations, "Password is too easy to guess")
+	}
+
+	if p.Breached != nil && password != "" {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			// An unreadable list must not stop users from changing
+			// passwords, the other rules still apply
+			log.Println("Failed to check breached password list:", err)
+		}
+		if breached {
+			violations = append(violations, "Password has appeared in a data breach, please choose another")
+		}
+	}
+
+	return violations
+}
+
+// containsPersonalInfo reports whether the password contains the email, its
+// local part or any word of the name. Fragments shorter than three
+// characters are ignored.
+func containsPersonalInfo(password, name, email string) bool {
+	lower := strings.ToLower(password)
+
+	fragments := strings.Fields(strings.ToLower(name))
+	if email = strings.ToLower(email); email != "" {
+		fragments = append(fragments, email)
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			fragments = append(fragments, email[:at])
+		}
+	}
+
+	for _, fragment := range fragments {
+		if len([]rune(fragment)) >= 3 && strings.Contains(lower, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPasswords are base words so common that any password built from them
+// by appending digits or symbols is guessed almost immediately
+var commonPasswords = map[string]bool{
+	"password": true, "passw0rd": true, "qwerty": true, "qwertyuiop": true,
+	"asdfgh": true, "letmein": true, "welcome": true, "admin": true,
+	"iloveyou": true, "monkey": true, "dragon": true, "football": true,
+	"baseball": true, "sunshine": true, "princess": true, "master": true,
+	"shadow": true, "superman": true, "trustno1": true, "hello": true,
+	"login": true, "secret": true, "changeme": true, "default": true,
+}
+
+// EstimatePasswordStrength scores a password from 0 (trivial to guess) to 4
+// (very strong). It estimates entropy from the character classes used and
+// discounts repeated characters, runs like "abc" or "321" and well known
+// passwords.
+func EstimatePasswordStrength(password string) int {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return 0
+	}
+	// Common words with digits or symbols appended are among the first guesses
+	base := strings.TrimRightFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	if commonPasswords[base] {
+		return 1
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	for _, r := range runes {
+		switch {
+		case r > unicode.MaxASCII:
+			hasOther = true
+		case 'a' <= r && r <= 'z':
+			hasLower = true
+		case 'A' <= r && r <= 'Z':
+			hasUpper = true
+		case '0' <= r && r <= '9':
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{hasLower, 26}, {hasUpper, 26}, {hasDigit, 10}, {hasSymbol, 33}, {hasOther, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+
+	effectiveLength := 1.0
+	for i := 1; i < len(runes); i++ {
+		if d := runes[i] - runes[i-1]; d >= -1 && d <= 1 {
+			effectiveLength += 0.25
+		} else {
+			effectiveLength++
+		}
+	}
+
+	bits := effectiveLength * math.Log2(float64(pool))
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 60:
+		return 2
+	case bits < 80:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// BreachedPasswordList looks passwords up in a local copy of a breached
+// password corpus stored in k-anonymity range format: one file per
+// five-character uppercase SHA-1 prefix (e.g. "21BD1" or "21BD1.txt")
+// holding "SUFFIX:COUNT" lines, as served by the Pwned Passwords range API.
+// Only the file of the password's prefix is read.
+type BreachedPasswordList struct {
+	dir string
+}
+
+// NewBreachedPasswordList opens the range files in dir
+func NewBreachedPasswordList(dir string) (*BreachedPasswordList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("breached password list: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password list: %s is not a directory", dir)
+	}
+	return &BreachedPasswordList{dir: dir}, nil
+}
+
+// Contains reports whether the password appears in the list
+func (l *BreachedPasswordList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(l.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(l.dir, prefix))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hashSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// Padding entries added by the range API have a count of zero
+		if strings.EqualFold(hashSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:          8,
+		MaxLength:          64,
+		RequireUppercase:   true,
+		RequireLowercase:   true,
+		RequireDigit:       true,
+		RequireSymbol:      true,
+		RejectPersonalInfo: true,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{
+			name:     "Valid password",
+			password: "Blue-Kettle-42",
+			want:     nil,
+		},
+		{
+			name:     "All violations at once",
+			password: "abc",
+			want: []string{
+				"Password must be at least 8 characters long",
+				"Password must contain an uppercase letter",
+				"Password must contain a digit",
+				"Password must contain a symbol",
+			},
+		},
+		{
+			name:     "Length counts characters, not bytes",
+			password: "Ünïcödé1!",
+			want:     nil,
+		},
+		{
+			name:     "Too long",
+			password: "Aa1!" + strings.Repeat("x", 61),
+			want:     []string{"Password must not exceed 64 characters"},
+		},
+		{
+			name:     "Contains name",
+			password: "Xjohnathan#9",
+			want:     []string{"Password must not contain your name or email address"},
+		},
+		{
+			name:     "Contains email local part",
+			password: "J.Doe-Rocks-1",
+			want:     []string{"Password must not contain your name or email address"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Check(tt.password, "Johnathan Doe", "j.doe@example.com")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyMinStrength(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 1, MinStrength: 3}
+
+	if got := policy.Check("password123", "", ""); len(got) != 1 || got[0] != "Password is too easy to guess" {
+		t.Errorf("Check() = %q, want the strength violation", got)
+	}
+	if got := policy.Check("correct horse battery staple", "", ""); got != nil {
+		t.Errorf("Check() = %q, want no violations", got)
+	}
+}
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", 0},
+		{"password", 0},
+		{"Password123!", 1},
+		{"12345678", 0},
+		{"aaaaaaaaaaaa", 0},
+		{"abcdefghijkl", 0},
+		{"k9#Lm2", 2},
+		{"tr0ub4dor&3x", 3},
+		{"correct horse battery staple", 4},
+	}
+
+	for _, tt := range tests {
+		if got := EstimatePasswordStrength(tt.password); got != tt.want {
+			t.Errorf("EstimatePasswordStrength(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestBreachedPasswordList(t *testing.T) {
+	dir := t.TempDir()
+
+	sum := sha1.Sum([]byte("hunter2"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	rangeFile := "0123456789ABCDEF0123456789ABCDEF012:4\r\n" + hash[5:] + ":17773\r\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(rangeFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := NewBreachedPasswordList(dir)
+	if err != nil {
+		t.Fatalf("NewBreachedPasswordList() error = %v", err)
+	}
+
+	if breached, err := list.Contains("hunter2"); err != nil || !breached {
+		t.Errorf("Contains(hunter2) = %v, %v, want true", breached, err)
+	}
+	if breached, err := list.Contains("not-in-the-list"); err != nil || breached {
+		t.Errorf("Contains(not-in-the-list) = %v, %v, want false", breached, err)
+	}
+
+	policy := &PasswordPolicy{MinLength: 6, Breached: list}
+	if got := policy.Check("hunter2", "", ""); len(got) != 1 {
+		t.Errorf("Check() = %q, want the breached violation", got)
+	}
+
+	if _, err := NewBreachedPasswordList(filepath.Join(dir, "missing")); err == nil {
+		t.Error("NewBreachedPasswordList() accepted a missing directory")
+	}
+}
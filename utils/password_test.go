@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -124,4 +127,111 @@ func TestPasswordHashingConsistency(t *testing.T) {
 	if err := ComparePassword(hash2, password); err != nil {
 		t.Errorf("Second hash failed to validate: %v", err)
 	}
-}
\ No newline at end of file
+}
+// useTestHasher installs a hasher for the duration of the test
+func useTestHasher(t *testing.T, hasher PasswordHasher) {
+	t.Helper()
+	SetPasswordHasher(hasher)
+	t.Cleanup(func() { SetPasswordHasher(nil) })
+}
+
+func testArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	useTestHasher(t, testArgon2idHasher())
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=2,p=1$") {
+		t.Errorf("HashPassword() = %v, want a PHC argon2id string", hash)
+	}
+	if err := ComparePassword(hash, "correct horse"); err != nil {
+		t.Errorf("ComparePassword() error = %v", err)
+	}
+	if err := ComparePassword(hash, "correct horsE"); err == nil {
+		t.Error("ComparePassword() accepted a wrong password")
+	}
+}
+
+func TestComparePasswordDetectsAlgorithm(t *testing.T) {
+	bcryptHash, _ := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("legacy-password")
+	argonHash, _ := testArgon2idHasher().Hash("modern-password")
+
+	// Whichever hasher is installed, hashes of the other algorithm still verify
+	for _, hasher := range []PasswordHasher{testArgon2idHasher(), &BcryptHasher{Cost: bcrypt.MinCost}} {
+		useTestHasher(t, hasher)
+
+		if err := ComparePassword(bcryptHash, "legacy-password"); err != nil {
+			t.Errorf("%T: ComparePassword(bcrypt) error = %v", hasher, err)
+		}
+		if err := ComparePassword(argonHash, "modern-password"); err != nil {
+			t.Errorf("%T: ComparePassword(argon2id) error = %v", hasher, err)
+		}
+	}
+
+	if err := ComparePassword("$argon2id$v=19$m=1024,t=2$c2FsdA$a2V5", "password"); err == nil {
+		t.Error("ComparePassword() accepted a malformed argon2id hash")
+	}
+	if err := ComparePassword("$argon2id$v=16$m=1024,t=2,p=1$c2FsdA$a2V5", "password"); err == nil {
+		t.Error("ComparePassword() accepted an unsupported argon2 version")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	weak := &Argon2idHasher{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	weakHash, _ := weak.Hash("password")
+	currentHash, _ := testArgon2idHasher().Hash("password")
+	bcryptHash, _ := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("password")
+
+	useTestHasher(t, testArgon2idHasher())
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{"Current parameters", currentHash, false},
+		{"Weaker parameters", weakHash, true},
+		{"Bcrypt hash", bcryptHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PasswordNeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("PasswordNeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A stronger hash than the configured one is kept
+	useTestHasher(t, weak)
+	if PasswordNeedsRehash(currentHash) {
+		t.Error("PasswordNeedsRehash() asked to downgrade a stronger hash")
+	}
+}
+
+func TestBcryptRejectsLongPasswords(t *testing.T) {
+	hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	prefix := strings.Repeat("a", 72)
+
+	hash, err := hasher.Hash(prefix)
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if err := hasher.Verify(hash, prefix+"anything"); err == nil {
+		t.Error("Verify() accepted a longer password sharing the first 72 bytes")
+	}
+	if _, err := hasher.Hash(prefix + "b"); err == nil {
+		t.Error("Hash() accepted a password longer than 72 bytes")
+	}
+
+	useTestHasher(t, hasher)
+	if violations := CheckPassword(prefix+"b", "", ""); len(violations) == 0 {
+		t.Error("CheckPassword() accepted a password bcrypt would truncate")
+	}
+}
@@ -10,6 +10,7 @@ type Response struct {
 	Message string      `json:"message,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Errors  []string    `json:"errors,omitempty"`
 }
 
 func RespondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
@@ -42,4 +43,15 @@ func RespondCreated(w http.ResponseWriter, message string, data interface{}) {
 		Data:    data,
 	}
 	RespondJSON(w, http.StatusCreated, response)
+}
+
+// RespondValidationErrors reports every failed validation at once. Error
+// carries the first one for clients that only display a single message.
+func RespondValidationErrors(w http.ResponseWriter, errors []string) {
+	response := Response{
+		Success: false,
+		Error:   errors[0],
+		Errors:  errors,
+	}
+	RespondJSON(w, http.StatusBadRequest, response)
 }
\ No newline at end of file
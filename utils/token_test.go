@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestGenerateSecureToken(t *testing.T) {
+	token1, err := GenerateSecureToken()
+	if err != nil {
+		t.Fatalf("GenerateSecureToken() error = %v", err)
+	}
+	token2, err := GenerateSecureToken()
+	if err != nil {
+		t.Fatalf("GenerateSecureToken() error = %v", err)
+	}
+
+	if len(token1) != 43 {
+		t.Errorf("GenerateSecureToken() length = %d, want 43", len(token1))
+	}
+	if token1 == token2 {
+		t.Error("GenerateSecureToken() returned the same token twice")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "Empty token",
+			token: "",
+			want:  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name:  "Simple token",
+			token: "abc",
+			want:  "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashToken(tt.token); got != tt.want {
+				t.Errorf("HashToken(%q) = %v, want %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed used by the RFC 6238 test vectors
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238 Appendix B vectors, truncated to 6 digits
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{"T=59", 59, "287082"},
+		{"T=1111111109", 1111111109, "081804"},
+		{"T=1111111111", 1111111111, "050471"},
+		{"T=1234567890", 1234567890, "005924"},
+		{"T=2000000000", 2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GenerateTOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatalf("GenerateTOTPCode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GenerateTOTPCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current, _ := GenerateTOTPCode(rfc6238Secret, TOTPStep(now))
+	previous, _ := GenerateTOTPCode(rfc6238Secret, TOTPStep(now)-1)
+	stale, _ := GenerateTOTPCode(rfc6238Secret, TOTPStep(now)-3)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantOk   bool
+		wantStep int64
+	}{
+		{"Current code", current, true, TOTPStep(now)},
+		{"Previous step within skew", previous, true, TOTPStep(now) - 1},
+		{"Code with spaces", current[:3] + " " + current[3:], true, TOTPStep(now)},
+		{"Code outside skew", stale, false, 0},
+		{"Wrong length", "12345", false, 0},
+		{"Empty code", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTPCode(rfc6238Secret, tt.code, now, 1)
+			if ok != tt.wantOk {
+				t.Errorf("ValidateTOTPCode() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if step != tt.wantStep {
+				t.Errorf("ValidateTOTPCode() step = %v, want %v", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("GenerateTOTPSecret() length = %d, want 32", len(secret))
+	}
+	if _, err := GenerateTOTPCode(secret, 1); err != nil {
+		t.Errorf("GenerateTOTPCode() rejected a generated secret: %v", err)
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Task Management", "john@example.com", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Task%20Management:john@example.com?") {
+		t.Errorf("TOTPProvisioningURI() = %v, unexpected label", uri)
+	}
+	for _, want := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Task+Management", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("TOTPProvisioningURI() = %v, missing %q", uri, want)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes, want 10", len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("recovery code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+
+		if got := NormalizeRecoveryCode(" " + strings.ToUpper(strings.ReplaceAll(code, "-", "")) + " "); got != code {
+			t.Errorf("NormalizeRecoveryCode() = %v, want %v", got, code)
+		}
+	}
+}
@@ -2,41 +2,86 @@ package utils
 
 import (
 	"net/mail"
-	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 )
 
-// ValidateEmail checks if the email format is valid
+// maxEmailLength is the longest address SMTP can carry (RFC 5321)
+const maxEmailLength = 254
+
+// ValidateEmail checks if the email format is valid. It expects a bare
+// address, as returned by NormalizeEmail; internationalized domains must be
+// valid IDNA names.
 func ValidateEmail(email string) bool {
-	_, err := mail.ParseAddress(email)
+	if len(email) > maxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return false
+	}
+	_, err = idna.Lookup.ToASCII(email[strings.LastIndex(email, "@")+1:])
 	return err == nil
 }
 
-// ValidatePassword checks if password meets requirements
-func ValidatePassword(password string) (bool, string) {
-	if len(password) < 6 {
-		return false, "Password must be at least 6 characters long"
+// NormalizeEmail returns the canonical form of an email address under which
+// it is stored and looked up: NFC-normalized and case-folded, with an
+// internationalized domain converted to ASCII (punycode). A domain that is
+// not a valid IDNA name is only lowercased and left for ValidateEmail to
+// reject.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return norm.NFC.String(cases.Fold().String(email))
+	}
+
+	local := norm.NFC.String(cases.Fold().String(email[:at]))
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		domain = strings.ToLower(email[at+1:])
 	}
-	if len(password) > 100 {
-		return false, "Password must not exceed 100 characters"
+	return local + "@" + domain
+}
+
+// NormalizeName trims the name and puts it in NFC form, so that names typed
+// with combining accents are stored like precomposed ones
+func NormalizeName(name string) string {
+	return norm.NFC.String(strings.TrimSpace(name))
+}
+
+// ValidatePassword checks if password meets the installed password policy
+// and returns the first requirement it fails. CheckPassword also checks the
+// password against the user's name and email and returns every violation.
+func ValidatePassword(password string) (bool, string) {
+	if violations := CheckPassword(password, "", ""); len(violations) > 0 {
+		return false, violations[0]
 	}
 	return true, ""
 }
 
-// ValidateName checks if name is valid
+// ValidateName checks if name is valid. Names may use letters of any script,
+// with their combining marks; the length is counted in characters after NFC
+// normalization.
 func ValidateName(name string) (bool, string) {
-	name = strings.TrimSpace(name)
-	if len(name) < 2 {
+	name = NormalizeName(name)
+	length := utf8.RuneCountInString(name)
+	if length < 2 {
 		return false, "Name must be at least 2 characters long"
 	}
-	if len(name) > 100 {
+	if length > 100 {
 		return false, "Name must not exceed 100 characters"
 	}
 
-	// Check if name contains only valid characters
-	validName := regexp.MustCompile(`^[a-zA-Z\s]+$`)
-	if !validName.MatchString(name) {
-		return false, "Name can only contain letters and spaces"
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsSpace(r) {
+			return false, "Name can only contain letters and spaces"
+		}
 	}
 
 	return true, ""
@@ -70,4 +115,35 @@ func ValidateTaskStatus(status string) bool {
 		"completed":   true,
 	}
 	return validStatuses[status]
-}
\ No newline at end of file
+}
+
+// ValidateLabelName checks if a label name is valid. Commas are not allowed
+// because the task list filters by comma-separated label names.
+func ValidateLabelName(name string) (bool, string) {
+	name = NormalizeName(name)
+	if name == "" {
+		return false, "Label name is required"
+	}
+	if utf8.RuneCountInString(name) > 50 {
+		return false, "Label name must not exceed 50 characters"
+	}
+	for _, r := range name {
+		if r == ',' || unicode.IsControl(r) {
+			return false, "Label name cannot contain commas or control characters"
+		}
+	}
+	return true, ""
+}
+
+// ValidateLabelColor checks if color is a hex color of the form #RRGGBB
+func ValidateLabelColor(color string) bool {
+	if len(color) != 7 || color[0] != '#' {
+		return false
+	}
+	for _, c := range color[1:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
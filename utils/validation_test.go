@@ -21,6 +21,12 @@ func TestValidateEmail(t *testing.T) {
 		{"Invalid - spaces", "test @example.com", false},
 		{"Invalid - double @", "test@@example.com", false},
 		{"Empty email", "", false},
+		{"Valid - punycode domain", "jose@xn--bcher-kva.example", true},
+		{"Valid - internationalized domain", "jose@bücher.example", true},
+		{"Valid - non-ASCII local part", "josé@example.com", true},
+		{"Invalid - display name", "John <john@example.com>", false},
+		{"Invalid - domain not IDNA", "user@exa_mple.com", false},
+		{"Invalid - too long", strings.Repeat("a", 243) + "@example.com", false},
 	}
 
 	for _, tt := range tests {
@@ -145,6 +151,48 @@ func TestValidateName(t *testing.T) {
 			wantOk:  false,
 			wantMsg: "Name must be at least 2 characters long",
 		},
+		{
+			name:    "Accented letters",
+			input:   "José Nguyễn",
+			wantOk:  true,
+			wantMsg: "",
+		},
+		{
+			name:    "Combining marks",
+			input:   "Jose\u0301",
+			wantOk:  true,
+			wantMsg: "",
+		},
+		{
+			name:    "Non-Latin script",
+			input:   "李小龍",
+			wantOk:  true,
+			wantMsg: "",
+		},
+		{
+			name:    "Maximum length in characters",
+			input:   strings.Repeat("é", 100),
+			wantOk:  true,
+			wantMsg: "",
+		},
+		{
+			name:    "Too long in characters",
+			input:   strings.Repeat("é", 101),
+			wantOk:  false,
+			wantMsg: "Name must not exceed 100 characters",
+		},
+		{
+			name:    "Single letter with combining mark",
+			input:   "e\u0301",
+			wantOk:  false,
+			wantMsg: "Name must be at least 2 characters long",
+		},
+		{
+			name:    "With symbols",
+			input:   "José ♥",
+			wantOk:  false,
+			wantMsg: "Name can only contain letters and spaces",
+		},
 	}
 
 	for _, tt := range tests {
@@ -160,6 +208,36 @@ func TestValidateName(t *testing.T) {
 	}
 }
 
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"Lowercases and trims", "  John.Doe@Example.COM ", "john.doe@example.com"},
+		{"Folds non-ASCII local part", "JOSÉ@example.com", "josé@example.com"},
+		{"Composes local part", "jose\u0301@example.com", "josé@example.com"},
+		{"Converts domain to punycode", "user@Bücher.example", "user@xn--bcher-kva.example"},
+		{"Keeps punycode domain", "user@xn--bcher-kva.example", "user@xn--bcher-kva.example"},
+		{"Lowercases invalid domain", "user@Exa_mple.com", "user@exa_mple.com"},
+		{"No @", "Not-An-Email", "not-an-email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeEmail(tt.email); got != tt.want {
+				t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	if got := NormalizeName("  Jose\u0301 "); got != "José" {
+		t.Errorf("NormalizeName() = %q, want %q", got, "José")
+	}
+}
+
 func TestValidateTaskTitle(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -286,4 +364,60 @@ func TestValidateTaskStatus(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestValidateLabelName(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantOk  bool
+		wantMsg string
+	}{
+		{"Valid name", "backend", true, ""},
+		{"With hyphen and digits", "customer-x 2", true, ""},
+		{"Non-Latin script", "バグ", true, ""},
+		{"Maximum length in characters", strings.Repeat("é", 50), true, ""},
+		{"Empty name", "", false, "Label name is required"},
+		{"Only spaces", "   ", false, "Label name is required"},
+		{"Too long", strings.Repeat("a", 51), false, "Label name must not exceed 50 characters"},
+		{"With comma", "bug,backend", false, "Label name cannot contain commas or control characters"},
+		{"With control character", "bug\tfix", false, "Label name cannot contain commas or control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, msg := ValidateLabelName(tt.input)
+			if ok != tt.wantOk {
+				t.Errorf("ValidateLabelName(%q) ok = %v, want %v", tt.input, ok, tt.wantOk)
+			}
+			if msg != tt.wantMsg {
+				t.Errorf("ValidateLabelName(%q) msg = %v, want %v", tt.input, msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestValidateLabelColor(t *testing.T) {
+	tests := []struct {
+		name  string
+		color string
+		want  bool
+	}{
+		{"Valid - lowercase", "#1e90ff", true},
+		{"Valid - uppercase", "#1E90FF", true},
+		{"Invalid - empty", "", false},
+		{"Invalid - missing hash", "1e90ff", false},
+		{"Invalid - short form", "#fff", false},
+		{"Invalid - non-hex digit", "#1e90fg", false},
+		{"Invalid - with alpha", "#1e90ff80", false},
+		{"Invalid - color name", "#purple", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateLabelColor(tt.color); got != tt.want {
+				t.Errorf("ValidateLabelColor(%q) = %v, want %v", tt.color, got, tt.want)
+			}
+		})
+	}
+}